@@ -0,0 +1,195 @@
+package ht2p
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/D3vl0per/crypt/generic"
+)
+
+type LimitAlgorithm int
+
+const (
+	// AIMD raises the limit by one for every success while the limit is in
+	// use and multiplies it by BackoffRatio on a failure or a response slower
+	// than LatencyThreshold.
+	AIMD LimitAlgorithm = iota
+	// Gradient scales the limit by the ratio of the long-term average latency
+	// to the latest one, allowing Tolerance times the average before it
+	// shrinks, plus a headroom of the square root of the limit.
+	Gradient
+)
+
+const (
+	DefaultAdaptiveInitialLimit = 20
+	DefaultAdaptiveMinLimit     = 1
+	DefaultAdaptiveMaxLimit     = 200
+	DefaultAdaptiveBackoffRatio = 0.9
+	DefaultAdaptiveTolerance    = 1.5
+	// gradientSmoothing is the weight of a new limit and of a new latency
+	// sample in the long-term average.
+	gradientSmoothing = 0.2
+)
+
+var ErrLoadShed = errors.New("request shed")
+
+// LoadSheddingError is returned without sending the request when Host already
+// has Limit requests in flight.
+type LoadSheddingError struct {
+	Host     string
+	Limit    int
+	InFlight int
+}
+
+func (e *LoadSheddingError) Error() string {
+	return generic.StrCnct([]string{"request shed [", e.Host, "]: ", strconv.Itoa(e.InFlight), " of ",
+		strconv.Itoa(e.Limit), " requests in flight"}...)
+}
+
+func (e *LoadSheddingError) Is(target error) bool {
+	return target == ErrLoadShed
+}
+
+// AdaptiveLimiter keeps a concurrency limit per host between MinLimit and
+// MaxLimit that follows the observed latency and failures, requests over the
+// limit are shed. A failure is what a CircuitBreaker would count, the latency
+// of a Stream call ends with its headers.
+type AdaptiveLimiter struct {
+	Algorithm        LimitAlgorithm
+	InitialLimit     int
+	MinLimit         int
+	MaxLimit         int
+	BackoffRatio     float64
+	LatencyThreshold time.Duration
+	Tolerance        float64
+
+	mu    sync.Mutex
+	hosts map[string]*adaptiveLimit
+}
+
+type adaptiveLimit struct {
+	limit       float64
+	inFlight    int
+	longLatency time.Duration
+}
+
+// Limit reports the current limit of a host, rawUrl may be a URL or the bare
+// host.
+func (l *AdaptiveLimiter) Limit(rawUrl string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.host(hostKey(rawUrl)).limit)
+}
+
+// host returns the limit of host, l.mu must be held.
+func (l *AdaptiveLimiter) host(host string) *adaptiveLimit {
+	if l.hosts == nil {
+		l.hosts = make(map[string]*adaptiveLimit)
+	}
+	state, ok := l.hosts[host]
+	if !ok {
+		initial := l.InitialLimit
+		if initial <= 0 {
+			initial = DefaultAdaptiveInitialLimit
+		}
+		state = &adaptiveLimit{limit: l.clamp(float64(initial))}
+		l.hosts[host] = state
+	}
+	return state
+}
+
+func (l *AdaptiveLimiter) clamp(limit float64) float64 {
+	minLimit, maxLimit := l.MinLimit, l.MaxLimit
+	if minLimit <= 0 {
+		minLimit = DefaultAdaptiveMinLimit
+	}
+	if maxLimit <= 0 {
+		maxLimit = DefaultAdaptiveMaxLimit
+	}
+	return math.Max(float64(minLimit), math.Min(float64(maxLimit), limit))
+}
+
+func (l *AdaptiveLimiter) acquire(host string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.host(host)
+	if limit := int(state.limit); state.inFlight >= limit {
+		return &LoadSheddingError{Host: host, Limit: limit, InFlight: state.inFlight}
+	}
+	state.inFlight++
+	return nil
+}
+
+func (l *AdaptiveLimiter) release(host string, latency time.Duration, response Response, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.host(host)
+	inFlight := state.inFlight
+	state.inFlight--
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	failed := endpointFailed(response, err)
+	backoff := l.BackoffRatio
+	if backoff <= 0 || backoff >= 1 {
+		backoff = DefaultAdaptiveBackoffRatio
+	}
+
+	switch l.Algorithm {
+	case Gradient:
+		if failed {
+			state.limit = l.clamp(state.limit * backoff)
+			return
+		}
+		if state.longLatency == 0 {
+			state.longLatency = latency
+		}
+		state.longLatency += time.Duration(gradientSmoothing * float64(latency-state.longLatency))
+
+		tolerance := l.Tolerance
+		if tolerance < 1 {
+			tolerance = DefaultAdaptiveTolerance
+		}
+		gradient := 1.0
+		if latency > 0 {
+			gradient = math.Max(0.5, math.Min(1, tolerance*float64(state.longLatency)/float64(latency)))
+		}
+		limit := state.limit*gradient + math.Sqrt(state.limit)
+		state.limit = l.clamp(state.limit*(1-gradientSmoothing) + limit*gradientSmoothing)
+	default:
+		if failed || (l.LatencyThreshold > 0 && latency > l.LatencyThreshold) {
+			state.limit = l.clamp(state.limit * backoff)
+			return
+		}
+		// Only raise a limit that is actually in use.
+		if float64(inFlight)*2 >= state.limit {
+			state.limit = l.clamp(state.limit + 1)
+		}
+	}
+}
+
+// wrap sheds requests over the limit of their host.
+func (l *AdaptiveLimiter) wrap(handler Handler) Handler {
+	if l == nil {
+		return handler
+	}
+
+	return func(ctx context.Context, request *OutgoingRequest) (Response, error) {
+		host := hostKey(request.URL)
+		if err := l.acquire(host); err != nil {
+			return Response{}, err
+		}
+
+		start := time.Now()
+		response, err := handler(ctx, request)
+		l.release(host, time.Since(start), response, err)
+		return response, err
+	}
+}
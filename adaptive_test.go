@@ -0,0 +1,130 @@
+package ht2p_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/D3vl0per/ht2p"
+	"github.com/stretchr/testify/assert"
+	r "github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	var delay atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(time.Duration(delay.Load()))
+		if req.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, err := w.Write([]byte("adaptive"))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		name          string
+		limiter       func() *ht2p.AdaptiveLimiter
+		delay         time.Duration
+		parameters    map[string]string
+		calls         int
+		expectedLimit int
+	}{
+		{
+			name:          "AIMD raises a used limit",
+			limiter:       func() *ht2p.AdaptiveLimiter { return &ht2p.AdaptiveLimiter{InitialLimit: 1} },
+			calls:         5,
+			expectedLimit: 3,
+		},
+		{
+			name:          "AIMD backs off on failures",
+			limiter:       func() *ht2p.AdaptiveLimiter { return &ht2p.AdaptiveLimiter{InitialLimit: 10} },
+			parameters:    map[string]string{"fail": "1"},
+			calls:         3,
+			expectedLimit: 7,
+		},
+		{
+			name: "AIMD backs off on slow responses",
+			limiter: func() *ht2p.AdaptiveLimiter {
+				return &ht2p.AdaptiveLimiter{InitialLimit: 10, BackoffRatio: 0.5, LatencyThreshold: 10 * time.Millisecond}
+			},
+			delay:         30 * time.Millisecond,
+			calls:         2,
+			expectedLimit: 2,
+		},
+		{
+			name: "AIMD keeps the bounds",
+			limiter: func() *ht2p.AdaptiveLimiter {
+				return &ht2p.AdaptiveLimiter{InitialLimit: 3, MinLimit: 2, BackoffRatio: 0.1}
+			},
+			parameters:    map[string]string{"fail": "1"},
+			calls:         3,
+			expectedLimit: 2,
+		},
+	}
+
+	for _, test := range tests {
+		for name, newClient := range backends {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				delay.Store(int64(test.delay))
+				limiter := test.limiter()
+				client := newClient(&ht2p.NetHttp{URL: server.URL, Concurrency: limiter})
+
+				for i := 0; i < test.calls; i++ {
+					_, _ = client.With(ht2p.Call{URLParameters: test.parameters}).Request()
+				}
+				r.Equal(t, test.expectedLimit, limiter.Limit(server.URL))
+			})
+		}
+	}
+
+	for name, newClient := range backends {
+		t.Run(name+" Gradient follows latency", func(t *testing.T) {
+			// The headroom of a small limit would hide the drop, and a short delay
+			// would make the steady latency jitter.
+			delay.Store(int64(10 * time.Millisecond))
+			limiter := &ht2p.AdaptiveLimiter{Algorithm: ht2p.Gradient, InitialLimit: 100}
+			client := newClient(&ht2p.NetHttp{URL: server.URL, Concurrency: limiter})
+
+			for i := 0; i < 5; i++ {
+				_, err := client.Request()
+				r.NoError(t, err)
+			}
+			steady := limiter.Limit(server.URL)
+			r.GreaterOrEqual(t, steady, 100)
+
+			delay.Store(int64(100 * time.Millisecond))
+			for i := 0; i < 3; i++ {
+				_, err := client.Request()
+				r.NoError(t, err)
+			}
+			r.Less(t, limiter.Limit(server.URL), steady)
+		})
+
+		t.Run(name+" Excess requests are shed", func(t *testing.T) {
+			delay.Store(int64(200 * time.Millisecond))
+			limiter := &ht2p.AdaptiveLimiter{InitialLimit: 1}
+			client := newClient(&ht2p.NetHttp{URL: server.URL, Concurrency: limiter})
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := client.Request()
+				done <- err
+			}()
+
+			var err error
+			r.Eventually(t, func() bool {
+				_, err = client.Request()
+				return err != nil
+			}, time.Second, 10*time.Millisecond)
+			r.ErrorIs(t, err, ht2p.ErrLoadShed)
+			var shedErr *ht2p.LoadSheddingError
+			r.ErrorAs(t, err, &shedErr)
+			r.Equal(t, 1, shedErr.Limit)
+			r.Equal(t, 1, shedErr.InFlight)
+			r.NoError(t, <-done)
+		})
+	}
+}
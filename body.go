@@ -0,0 +1,119 @@
+package ht2p
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"github.com/D3vl0per/crypt/compression"
+	"github.com/D3vl0per/crypt/generic"
+)
+
+var ErrBodyNotReplayable = errors.New("request body reader already consumed and GetBody is not set [request body]")
+
+// requestBody hands out the request body for every attempt of a call. Raw
+// bodies are replayed freely, a reader only once unless GetBody is set.
+type requestBody struct {
+	raw     []byte
+	reader  io.Reader
+	length  int64
+	getBody func() (io.ReadCloser, error)
+
+	// encoding is the Content-Encoding of the body, readers are compressed
+	// with compressor on every open.
+	encoding   string
+	compressor compression.Compressor
+
+	// consumed is shared by the calls of a client when reader is its BodyReader.
+	consumed *atomic.Bool
+}
+
+func newRequestBody(raw []byte, reader io.Reader, length int64, getBody func() (io.ReadCloser, error), consumed *atomic.Bool) *requestBody {
+	if reader == nil && getBody == nil {
+		if raw == nil {
+			return nil
+		}
+		return &requestBody{raw: raw, length: int64(len(raw))}
+	}
+
+	if length <= 0 {
+		length = -1
+	}
+
+	if consumed == nil {
+		consumed = new(atomic.Bool)
+	}
+
+	return &requestBody{
+		reader:   reader,
+		length:   length,
+		getBody:  getBody,
+		consumed: consumed,
+	}
+}
+
+// open returns the body for the next attempt and its length, -1 when unknown.
+// The caller's BodyReader is never closed, readers from GetBody are.
+func (b *requestBody) open() (io.ReadCloser, int64, error) {
+	if b.buffered() {
+		return io.NopCloser(bytes.NewReader(b.raw)), b.length, nil
+	}
+
+	if b.reader != nil && b.consumed.CompareAndSwap(false, true) {
+		return b.compressStream(io.NopCloser(b.reader)), b.length, nil
+	}
+
+	if b.getBody == nil {
+		return nil, 0, ErrBodyNotReplayable
+	}
+
+	reader, err := b.getBody()
+	if err != nil {
+		return nil, 0, err
+	}
+	return b.compressStream(reader), b.length, nil
+}
+
+// compress encodes the body with compressor unless its known length is below
+// minSize. Raw bodies are compressed once here, readers while they are sent.
+func (b *requestBody) compress(compressor compression.Compressor, minSize int64) error {
+	if b == nil || compressor == nil || (b.length >= 0 && b.length < minSize) {
+		return nil
+	}
+
+	b.encoding = contentCoding(compressor)
+	if !b.buffered() {
+		b.compressor = compressor
+		b.length = -1
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	if err := newCompressor(compressor).CompressStream(bytes.NewReader(b.raw), &buffer); err != nil {
+		return errors.New(generic.StrCnct([]string{"failed to compress request body [", b.encoding, "]: ", err.Error()}...))
+	}
+	b.raw = buffer.Bytes()
+	b.length = int64(len(b.raw))
+	return nil
+}
+
+func (b *requestBody) compressStream(source io.ReadCloser) io.ReadCloser {
+	if b.compressor == nil {
+		return source
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(newCompressor(b.compressor).CompressStream(source, writer))
+	}()
+	return &pipeBody{PipeReader: reader, source: source}
+}
+
+func (b *requestBody) buffered() bool {
+	return b.reader == nil && b.getBody == nil
+}
+
+func (b *requestBody) replayable() bool {
+	return b.buffered() || b.getBody != nil
+}
@@ -0,0 +1,262 @@
+package ht2p_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/D3vl0per/crypt/compression"
+	"github.com/D3vl0per/ht2p"
+	"github.com/stretchr/testify/assert"
+	r "github.com/stretchr/testify/require"
+)
+
+func TestBodyReader(t *testing.T) {
+	payload := strings.Repeat("upload ", 4096)
+
+	var failures atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, payload, string(body))
+
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("X-Chunked", strconv.FormatBool(len(req.TransferEncoding) != 0))
+	}))
+	t.Cleanup(server.Close)
+
+	policy := &ht2p.RetryPolicy{InitialBackoff: time.Millisecond}
+	getBody := func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(payload)), nil
+	}
+
+	tests := []struct {
+		name             string
+		length           int64
+		getBody          func() (io.ReadCloser, error)
+		failures         int32
+		expectedAttempts int
+		expectedChunked  string
+		expectedError    bool
+	}{
+		{
+			name:             "Known length",
+			length:           int64(len(payload)),
+			expectedAttempts: 1,
+			expectedChunked:  "false",
+		},
+		{
+			name:             "Chunked transfer",
+			expectedAttempts: 1,
+			expectedChunked:  "true",
+		},
+		{
+			name:             "Replayed with GetBody",
+			length:           int64(len(payload)),
+			getBody:          getBody,
+			failures:         2,
+			expectedAttempts: 3,
+			expectedChunked:  "false",
+		},
+		{
+			name:             "Not replayable without GetBody",
+			failures:         1,
+			expectedAttempts: 1,
+			expectedError:    true,
+		},
+	}
+
+	for _, test := range tests {
+		for name, newClient := range backends {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				failures.Store(test.failures)
+
+				response, err := newClient(&ht2p.NetHttp{
+					URL:        server.URL,
+					Method:     http.MethodPut,
+					BodyReader: strings.NewReader(payload),
+					BodyLength: test.length,
+					GetBody:    test.getBody,
+					Ctx:        context.Background(),
+					Retry:      policy,
+				}).Request()
+				r.Equal(t, test.expectedAttempts, response.Attempts)
+
+				if test.expectedError {
+					r.Error(t, err)
+					r.NotErrorIs(t, err, ht2p.ErrBodyNotReplayable)
+					r.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+					return
+				}
+				r.NoError(t, err)
+				r.Equal(t, []string{test.expectedChunked}, response.Headers["X-Chunked"])
+			})
+		}
+	}
+}
+
+func TestBodyReaderCalls(t *testing.T) {
+	payload := strings.Repeat("upload ", 4096)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+		_, err = w.Write(body)
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+
+	getBody := func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(payload)), nil
+	}
+
+	for name, newClient := range backends {
+		t.Run(name+" Later calls use GetBody", func(t *testing.T) {
+			client := newClient(&ht2p.NetHttp{
+				URL:        server.URL,
+				Method:     http.MethodPut,
+				BodyReader: strings.NewReader(payload),
+				GetBody:    getBody,
+			})
+
+			for i := 0; i < 2; i++ {
+				response, err := client.Request()
+				r.NoError(t, err)
+				r.Equal(t, payload, string(response.Body))
+			}
+		})
+
+		t.Run(name+" Concurrent calls use GetBody", func(t *testing.T) {
+			client := newClient(&ht2p.NetHttp{
+				URL:        server.URL,
+				Method:     http.MethodPut,
+				BodyReader: strings.NewReader(payload),
+				GetBody:    getBody,
+			})
+
+			bodies := make(chan string, 4)
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					response, err := client.Request()
+					assert.NoError(t, err)
+					bodies <- string(response.Body)
+				}()
+			}
+			wg.Wait()
+			close(bodies)
+
+			for body := range bodies {
+				r.Equal(t, payload, body)
+			}
+		})
+
+		t.Run(name+" Not replayable without GetBody", func(t *testing.T) {
+			client := newClient(&ht2p.NetHttp{
+				URL:        server.URL,
+				Method:     http.MethodPut,
+				BodyReader: strings.NewReader(payload),
+			})
+
+			response, err := client.Request()
+			r.NoError(t, err)
+			r.Equal(t, payload, string(response.Body))
+
+			_, err = client.Request()
+			r.ErrorIs(t, err, ht2p.ErrBodyNotReplayable)
+
+			// A reader passed with the call is its own.
+			response, err = client.With(ht2p.Call{BodyReader: strings.NewReader("call")}).Request()
+			r.NoError(t, err)
+			r.Equal(t, "call", string(response.Body))
+		})
+	}
+}
+
+func TestBodyCompression(t *testing.T) {
+	payload := strings.Repeat(`{"event":"ingest"}`, 4096)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+
+		encoding := req.Header.Get("Content-Encoding")
+		if compressor := compressorByName(encoding); compressor != nil {
+			body, err = compressor.Decompress(body)
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, payload, string(body))
+		w.Header().Set("X-Content-Encoding", encoding)
+	}))
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		name             string
+		compressor       compression.Compressor
+		minSize          int64
+		reader           bool
+		expectedEncoding string
+	}{
+		{name: "Uncompressed", expectedEncoding: ""},
+		{name: "Gzip", compressor: &compression.Gzip{Level: compression.BestSpeed}, expectedEncoding: "gzip"},
+		{name: "Zlib", compressor: &compression.Zlib{Level: compression.BestSpeed}, expectedEncoding: "deflate"},
+		{name: "Flate", compressor: &compression.Flate{Level: compression.BestSpeed}, expectedEncoding: "deflate"},
+		{name: "Zstd", compressor: &compression.Zstd{Level: compression.ZstdSpeedFastest}, expectedEncoding: "zstd"},
+		{name: "Brotli", compressor: &compression.Brotli{}, expectedEncoding: "br"},
+		{name: "Below threshold", compressor: &compression.Gzip{}, minSize: int64(len(payload)) + 1, expectedEncoding: ""},
+		{name: "Above threshold", compressor: &compression.Gzip{}, minSize: int64(len(payload)), expectedEncoding: "gzip"},
+		{name: "Streamed reader", compressor: &compression.Zstd{}, reader: true, expectedEncoding: "zstd"},
+	}
+
+	for _, test := range tests {
+		var body []byte
+		var bodyReader io.Reader
+		if test.reader {
+			bodyReader = strings.NewReader(payload)
+		} else {
+			body = []byte(payload)
+		}
+
+		for name, client := range map[string]ht2p.HttpClient{
+			"net/http": &ht2p.NetHttp{
+				URL:                    server.URL,
+				Method:                 http.MethodPost,
+				Body:                   body,
+				BodyReader:             bodyReader,
+				BodyCompressor:         test.compressor,
+				BodyCompressionMinSize: test.minSize,
+				Ctx:                    context.Background(),
+			},
+			"fasthttp": &ht2p.FastHttp{
+				URL:                    server.URL,
+				Method:                 http.MethodPost,
+				Body:                   body,
+				BodyReader:             bodyReader,
+				BodyCompressor:         test.compressor,
+				BodyCompressionMinSize: test.minSize,
+			},
+		} {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				if test.reader {
+					bodyReader.(*strings.Reader).Reset(payload)
+				}
+
+				response, err := client.Request()
+				r.NoError(t, err)
+				r.Equal(t, []string{test.expectedEncoding}, response.Headers["X-Content-Encoding"])
+			})
+		}
+	}
+}
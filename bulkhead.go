@@ -0,0 +1,232 @@
+package ht2p
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/D3vl0per/crypt/generic"
+)
+
+const (
+	bulkheadQueueFull    = "queue full"
+	bulkheadQueueTimeout = "queue timeout"
+)
+
+var ErrBulkheadFull = errors.New("bulkhead full")
+
+// BulkheadError is returned when a request found no free slot and could not
+// queue, or left the queue before a slot became free. Wait is the time spent
+// in the queue.
+type BulkheadError struct {
+	Host   string
+	Reason string
+	Wait   time.Duration
+	Err    error
+}
+
+func (e *BulkheadError) Error() string {
+	message := generic.StrCnct([]string{"bulkhead rejected request [", e.Host, "]: ", e.Reason}...)
+	if e.Err != nil {
+		message = generic.StrCnct([]string{message, ": ", e.Err.Error()}...)
+	}
+	return message
+}
+
+func (e *BulkheadError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrBulkheadFull}
+	}
+	return []error{ErrBulkheadFull, e.Err}
+}
+
+// Bulkhead bounds the requests in flight per host with MaxPerHost and over all
+// hosts with MaxTotal, zero leaves a bound out. Requests over a bound wait in
+// a queue of at most MaxQueue requests (unbounded when zero, no queue when
+// negative) for QueueTimeout or until their context ends. Queued requests of
+// one host never hold back other hosts with free slots. A Stream call frees
+// its slot when Stream returns, not when the body is closed.
+type Bulkhead struct {
+	MaxPerHost   int
+	MaxTotal     int
+	MaxQueue     int
+	QueueTimeout time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	hosts    map[string]*compartment
+	queue    []*bulkheadWaiter
+}
+
+type compartment struct {
+	inFlight int
+	queued   int
+	waits    int64
+	waitTime time.Duration
+	maxWait  time.Duration
+}
+
+type bulkheadWaiter struct {
+	host     string
+	ready    chan struct{}
+	admitted bool
+}
+
+// BulkheadStats is a snapshot of a Bulkhead, the wait times cover the requests
+// admitted from the queue.
+type BulkheadStats struct {
+	InFlight int
+	Queued   int
+	Hosts    map[string]HostBulkheadStats
+}
+
+type HostBulkheadStats struct {
+	InFlight int
+	Queued   int
+	Waits    int64
+	WaitTime time.Duration
+	MaxWait  time.Duration
+}
+
+func (b *Bulkhead) Stats() BulkheadStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := BulkheadStats{InFlight: b.inFlight, Queued: len(b.queue), Hosts: make(map[string]HostBulkheadStats, len(b.hosts))}
+	for host, c := range b.hosts {
+		stats.Hosts[host] = HostBulkheadStats{
+			InFlight: c.inFlight,
+			Queued:   c.queued,
+			Waits:    c.waits,
+			WaitTime: c.waitTime,
+			MaxWait:  c.maxWait,
+		}
+	}
+	return stats
+}
+
+// compartment returns the counters of host, b.mu must be held.
+func (b *Bulkhead) compartment(host string) *compartment {
+	if b.hosts == nil {
+		b.hosts = make(map[string]*compartment)
+	}
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &compartment{}
+		b.hosts[host] = c
+	}
+	return c
+}
+
+func (b *Bulkhead) fits(c *compartment) bool {
+	return (b.MaxPerHost <= 0 || c.inFlight < b.MaxPerHost) && (b.MaxTotal <= 0 || b.inFlight < b.MaxTotal)
+}
+
+func (b *Bulkhead) acquire(ctx context.Context, host string) error {
+	b.mu.Lock()
+	c := b.compartment(host)
+	if b.fits(c) {
+		c.inFlight++
+		b.inFlight++
+		b.mu.Unlock()
+		return nil
+	}
+
+	if b.MaxQueue < 0 || (b.MaxQueue > 0 && len(b.queue) >= b.MaxQueue) {
+		b.mu.Unlock()
+		return &BulkheadError{Host: host, Reason: bulkheadQueueFull}
+	}
+
+	waiter := &bulkheadWaiter{host: host, ready: make(chan struct{})}
+	b.queue = append(b.queue, waiter)
+	c.queued++
+	b.mu.Unlock()
+
+	start := time.Now()
+	var timeout <-chan time.Time
+	if b.QueueTimeout > 0 {
+		timer := time.NewTimer(b.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-waiter.ready:
+	case <-timeout:
+		err = &BulkheadError{Host: host, Reason: bulkheadQueueTimeout, Wait: time.Since(start)}
+	case <-ctx.Done():
+		err = &BulkheadError{Host: host, Reason: bulkheadQueueTimeout, Wait: time.Since(start), Err: context.Cause(ctx)}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wait := time.Since(start)
+	if err == nil || waiter.admitted {
+		c.waits++
+		c.waitTime += wait
+		if wait > c.maxWait {
+			c.maxWait = wait
+		}
+	}
+
+	switch {
+	case err != nil && waiter.admitted:
+		// The slot was handed over while the wait ended.
+		b.releaseLocked(host)
+	case err != nil:
+		c.queued--
+		for i, queued := range b.queue {
+			if queued == waiter {
+				b.queue = append(b.queue[:i], b.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	return err
+}
+
+func (b *Bulkhead) release(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.releaseLocked(host)
+}
+
+// releaseLocked frees a slot and hands free slots to the queued requests in
+// order, b.mu must be held.
+func (b *Bulkhead) releaseLocked(host string) {
+	b.hosts[host].inFlight--
+	b.inFlight--
+
+	queue := b.queue[:0]
+	for _, waiter := range b.queue {
+		c := b.hosts[waiter.host]
+		if !b.fits(c) {
+			queue = append(queue, waiter)
+			continue
+		}
+		c.inFlight++
+		c.queued--
+		b.inFlight++
+		waiter.admitted = true
+		close(waiter.ready)
+	}
+	b.queue = queue
+}
+
+// wrap holds a slot while handler runs.
+func (b *Bulkhead) wrap(handler Handler) Handler {
+	if b == nil {
+		return handler
+	}
+
+	return func(ctx context.Context, request *OutgoingRequest) (Response, error) {
+		host := hostKey(request.URL)
+		if err := b.acquire(ctx, host); err != nil {
+			return Response{}, err
+		}
+		defer b.release(host)
+		return handler(ctx, request)
+	}
+}
@@ -0,0 +1,104 @@
+package ht2p_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/D3vl0per/ht2p"
+	r "github.com/stretchr/testify/require"
+)
+
+func TestBulkhead(t *testing.T) {
+	slow := testServer(t, 200*time.Millisecond, http.StatusOK, "slow")
+	fast := testServer(t, 0, http.StatusOK, "fast")
+	slowHost := strings.TrimPrefix(slow.URL, "http://")
+
+	tests := []struct {
+		name           string
+		bulkhead       func() *ht2p.Bulkhead
+		url            string
+		expectedReason string
+		minDuration    time.Duration
+		maxDuration    time.Duration
+	}{
+		{
+			name:        "Other hosts are not held back",
+			bulkhead:    func() *ht2p.Bulkhead { return &ht2p.Bulkhead{MaxPerHost: 1} },
+			url:         fast.URL,
+			maxDuration: 100 * time.Millisecond,
+		},
+		{
+			name:        "Queued until a slot is free",
+			bulkhead:    func() *ht2p.Bulkhead { return &ht2p.Bulkhead{MaxPerHost: 1} },
+			url:         slow.URL,
+			minDuration: 300 * time.Millisecond,
+		},
+		{
+			name:        "Global limit",
+			bulkhead:    func() *ht2p.Bulkhead { return &ht2p.Bulkhead{MaxTotal: 1} },
+			url:         fast.URL,
+			minDuration: 100 * time.Millisecond,
+		},
+		{
+			name:           "Queue full",
+			bulkhead:       func() *ht2p.Bulkhead { return &ht2p.Bulkhead{MaxPerHost: 1, MaxQueue: -1} },
+			url:            slow.URL,
+			expectedReason: "queue full",
+			maxDuration:    100 * time.Millisecond,
+		},
+		{
+			name:           "Queue timeout",
+			bulkhead:       func() *ht2p.Bulkhead { return &ht2p.Bulkhead{MaxPerHost: 1, QueueTimeout: 50 * time.Millisecond} },
+			url:            slow.URL,
+			expectedReason: "queue timeout",
+			minDuration:    50 * time.Millisecond,
+			maxDuration:    150 * time.Millisecond,
+		},
+	}
+
+	for _, test := range tests {
+		for name, newClient := range backends {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				bulkhead := test.bulkhead()
+				client := newClient(&ht2p.NetHttp{Bulkhead: bulkhead})
+
+				blocking := make(chan error, 1)
+				go func() {
+					blocking <- client.FanOut([]string{slow.URL}, 1)[0].Err
+				}()
+				r.Eventually(t, func() bool { return bulkhead.Stats().InFlight == 1 }, time.Second, time.Millisecond)
+
+				start := time.Now()
+				results := client.FanOut([]string{test.url}, 1)
+				duration := time.Since(start)
+
+				if test.expectedReason != "" {
+					r.ErrorIs(t, results[0].Err, ht2p.ErrBulkheadFull)
+					var bulkheadErr *ht2p.BulkheadError
+					r.ErrorAs(t, results[0].Err, &bulkheadErr)
+					r.Equal(t, test.expectedReason, bulkheadErr.Reason)
+				} else {
+					r.NoError(t, results[0].Err)
+				}
+
+				if test.minDuration != 0 {
+					r.GreaterOrEqual(t, duration, test.minDuration)
+				}
+				if test.maxDuration != 0 {
+					r.Less(t, duration, test.maxDuration)
+				}
+
+				r.NoError(t, <-blocking)
+				stats := bulkhead.Stats()
+				r.Zero(t, stats.InFlight)
+				r.Zero(t, stats.Queued)
+				if test.name == "Queued until a slot is free" {
+					r.EqualValues(t, 1, stats.Hosts[slowHost].Waits)
+					r.GreaterOrEqual(t, stats.Hosts[slowHost].MaxWait, 100*time.Millisecond)
+				}
+			})
+		}
+	}
+}
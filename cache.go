@@ -0,0 +1,454 @@
+package ht2p
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCacheMaxEntries = 1024
+	// heuristicFraction of the time since Last-Modified is used as freshness
+	// lifetime when the response has no explicit one.
+	heuristicFraction = 0.1
+)
+
+// Cache is a private RFC 9111 cache for GET and HEAD requests. Freshness comes
+// from Cache-Control max-age, Expires or a heuristic on Last-Modified, stored
+// responses are selected by their Vary headers. Stale responses with an ETag
+// or Last-Modified are revalidated with If-None-Match and If-Modified-Since,
+// a 304 is answered from the cache. stale-while-revalidate answers from the
+// cache while revalidating in the background and stale-if-error answers from
+// the cache when the upstream fails. Requests carrying their own conditional
+// headers bypass the cache, unsafe methods invalidate the stored responses of
+// their URL. Stream calls are not cached.
+type Cache struct {
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string][]*cacheEntry
+	lru     *list.List
+}
+
+type cacheEntry struct {
+	key          string
+	vary         map[string]string
+	response     Response
+	requestTime  time.Time
+	responseTime time.Time
+	initialAge   time.Duration
+	control      map[string]string
+	revalidating bool
+	element      *list.Element
+}
+
+func cacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, directive := range strings.Split(value, ",") {
+		name, argument, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			directives[name] = strings.Trim(strings.TrimSpace(argument), `"`)
+		}
+	}
+	return directives
+}
+
+// seconds reads a delta-seconds directive argument.
+func seconds(directives map[string]string, name string) (time.Duration, bool) {
+	argument, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(argument, 10, 64)
+	if err != nil || value < 0 {
+		return 0, false
+	}
+	return time.Duration(value) * time.Second, true
+}
+
+func requestHeader(headers map[string]string, key string) string {
+	if value, ok := headers[key]; ok {
+		return value
+	}
+	for name, value := range headers {
+		if strings.EqualFold(name, key) {
+			return value
+		}
+	}
+	return ""
+}
+
+func heuristicallyCacheable(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusPermanentRedirect, http.StatusNotFound, http.StatusMethodNotAllowed,
+		http.StatusGone, http.StatusRequestURITooLong, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// freshness is the freshness lifetime of the stored response.
+func (e *cacheEntry) freshness() time.Duration {
+	if maxAge, ok := seconds(e.control, "max-age"); ok {
+		return maxAge
+	}
+
+	date, err := http.ParseTime(headerValue(e.response.Headers, "Date"))
+	if err != nil {
+		date = e.responseTime
+	}
+
+	if expires := headerValue(e.response.Headers, "Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return expiresAt.Sub(date)
+	}
+
+	if lastModified, err := http.ParseTime(headerValue(e.response.Headers, "Last-Modified")); err == nil && heuristicallyCacheable(e.response.StatusCode) {
+		return time.Duration(heuristicFraction * float64(date.Sub(lastModified)))
+	}
+	return 0
+}
+
+func (e *cacheEntry) explicit() bool {
+	_, ok := seconds(e.control, "max-age")
+	return ok || headerValue(e.response.Headers, "Expires") != ""
+}
+
+func (e *cacheEntry) validators() bool {
+	return headerValue(e.response.Headers, "ETag") != "" || headerValue(e.response.Headers, "Last-Modified") != ""
+}
+
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	return e.initialAge + now.Sub(e.responseTime)
+}
+
+// setAge computes the age of a response when it was received.
+func (e *cacheEntry) setAge() {
+	var apparent time.Duration
+	if date, err := http.ParseTime(headerValue(e.response.Headers, "Date")); err == nil && e.responseTime.After(date) {
+		apparent = e.responseTime.Sub(date)
+	}
+
+	corrected := e.responseTime.Sub(e.requestTime)
+	if age, err := strconv.ParseInt(headerValue(e.response.Headers, "Age"), 10, 64); err == nil && age > 0 {
+		corrected += time.Duration(age) * time.Second
+	}
+
+	e.initialAge = corrected
+	if apparent > corrected {
+		e.initialAge = apparent
+	}
+}
+
+// stale reports whether a stale entry may still be served within the window
+// of directive, must-revalidate forbids it.
+func (e *cacheEntry) stale(directive string, requestControl map[string]string, staleness time.Duration) bool {
+	if _, ok := e.control["must-revalidate"]; ok {
+		return false
+	}
+	window, ok := seconds(e.control, directive)
+	if requestWindow, requestOk := seconds(requestControl, directive); requestOk {
+		window, ok = requestWindow, true
+	}
+	return ok && staleness <= window
+}
+
+// serve returns a copy of the stored response with its current Age.
+func (e *cacheEntry) serve(now time.Time) Response {
+	response := copyResponse(e.response)
+	deleteHeader(response.Headers, "Age")
+	response.Headers["Age"] = []string{strconv.FormatInt(int64(e.age(now)/time.Second), 10)}
+	return response
+}
+
+// copyResponse keeps callers from changing a stored response.
+func copyResponse(response Response) Response {
+	copied := response
+	copied.Body = append([]byte(nil), response.Body...)
+	copied.Headers = make(map[string][]string, len(response.Headers)+1)
+	for key, values := range response.Headers {
+		copied.Headers[key] = append([]string(nil), values...)
+	}
+	return copied
+}
+
+func cacheKey(request *OutgoingRequest) string {
+	return request.Method + " " + request.URL
+}
+
+func (c *Cache) lookup(request *OutgoingRequest) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, entry := range c.entries[cacheKey(request)] {
+		matched := true
+		for name, value := range entry.vary {
+			if requestHeader(request.Headers, name) != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			c.lru.MoveToFront(entry.element)
+			return entry
+		}
+	}
+	return nil
+}
+
+// store keeps a response if it may be cached, replacing the entry with the
+// same Vary values.
+func (c *Cache) store(request *OutgoingRequest, response Response, requestTime time.Time) {
+	entry := &cacheEntry{
+		key:          cacheKey(request),
+		response:     copyResponse(response),
+		requestTime:  requestTime,
+		responseTime: time.Now(),
+		control:      cacheControl(headerValue(response.Headers, "Cache-Control")),
+	}
+
+	if _, ok := entry.control["no-store"]; ok {
+		return
+	}
+	if !entry.explicit() && !entry.validators() {
+		return
+	}
+	if !entry.explicit() && !heuristicallyCacheable(response.StatusCode) {
+		return
+	}
+
+	entry.vary = make(map[string]string)
+	for key, values := range response.Headers {
+		if !strings.EqualFold(key, "Vary") {
+			continue
+		}
+		for _, name := range strings.Split(strings.Join(values, ","), ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return
+			}
+			if name != "" {
+				entry.vary[name] = requestHeader(request.Headers, name)
+			}
+		}
+	}
+	entry.setAge()
+	c.insert(entry)
+}
+
+func (c *Cache) insert(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string][]*cacheEntry)
+		c.lru = list.New()
+	}
+
+	entries := c.entries[entry.key][:0:0]
+	for _, stored := range c.entries[entry.key] {
+		if sameVary(stored.vary, entry.vary) {
+			c.lru.Remove(stored.element)
+			continue
+		}
+		entries = append(entries, stored)
+	}
+	entry.element = c.lru.PushFront(entry)
+	c.entries[entry.key] = append(entries, entry)
+
+	maxEntries := c.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+	for c.lru.Len() > maxEntries {
+		c.removeLocked(c.lru.Back().Value.(*cacheEntry))
+	}
+}
+
+func sameVary(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if other, ok := b[name]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// removeLocked drops an entry, c.mu must be held.
+func (c *Cache) removeLocked(entry *cacheEntry) {
+	c.lru.Remove(entry.element)
+	entries := c.entries[entry.key][:0:0]
+	for _, stored := range c.entries[entry.key] {
+		if stored != entry {
+			entries = append(entries, stored)
+		}
+	}
+	if len(entries) == 0 {
+		delete(c.entries, entry.key)
+		return
+	}
+	c.entries[entry.key] = entries
+}
+
+// invalidate drops the stored responses of a URL after an unsafe request.
+func (c *Cache) invalidate(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		for _, entry := range c.entries[method+" "+url] {
+			c.removeLocked(entry)
+		}
+	}
+}
+
+// refresh updates a stored response with the headers of a 304.
+func (c *Cache) refresh(entry *cacheEntry, notModified Response, requestTime time.Time) *cacheEntry {
+	c.mu.Lock()
+	refreshed := *entry
+	c.mu.Unlock()
+	refreshed.response.Headers = make(map[string][]string, len(entry.response.Headers))
+	for key, values := range entry.response.Headers {
+		refreshed.response.Headers[key] = values
+	}
+	for key, values := range notModified.Headers {
+		if strings.EqualFold(key, "Content-Length") || strings.EqualFold(key, "Content-Encoding") || strings.EqualFold(key, "Transfer-Encoding") {
+			continue
+		}
+		deleteHeader(refreshed.response.Headers, key)
+		refreshed.response.Headers[key] = values
+	}
+	refreshed.requestTime, refreshed.responseTime = requestTime, time.Now()
+	refreshed.control = cacheControl(headerValue(refreshed.response.Headers, "Cache-Control"))
+	refreshed.revalidating = false
+	refreshed.setAge()
+	c.insert(&refreshed)
+	return &refreshed
+}
+
+// conditional adds the validators of entry to a request.
+func (e *cacheEntry) conditional(request *OutgoingRequest) {
+	if etag := headerValue(e.response.Headers, "ETag"); etag != "" {
+		request.Headers["If-None-Match"] = etag
+	}
+	if lastModified := headerValue(e.response.Headers, "Last-Modified"); lastModified != "" {
+		request.Headers["If-Modified-Since"] = lastModified
+	}
+}
+
+// revalidate sends a request for entry and stores the outcome.
+// The flag reports a 304 answered from the cache.
+func (c *Cache) revalidate(ctx context.Context, next Handler, request *OutgoingRequest, entry *cacheEntry) (Response, bool, error) {
+	entry.conditional(request)
+	requestTime := time.Now()
+	response, err := next(ctx, request)
+	if response.StatusCode == http.StatusNotModified {
+		return c.refresh(entry, response, requestTime).serve(time.Now()), true, nil
+	}
+	if err == nil {
+		c.store(request, response, requestTime)
+	}
+	return response, false, err
+}
+
+// background revalidates entry once at a time while its stale response is
+// served.
+func (c *Cache) background(ctx context.Context, next Handler, request *OutgoingRequest, entry *cacheEntry) {
+	c.mu.Lock()
+	if entry.revalidating {
+		c.mu.Unlock()
+		return
+	}
+	entry.revalidating = true
+	c.mu.Unlock()
+
+	copied := *request
+	copied.Headers = make(map[string]string, len(request.Headers)+2)
+	for key, value := range request.Headers {
+		copied.Headers[key] = value
+	}
+
+	go func() {
+		_, _, err := c.revalidate(context.WithoutCancel(ctx), next, &copied, entry)
+		if err != nil {
+			c.mu.Lock()
+			entry.revalidating = false
+			c.mu.Unlock()
+		}
+	}()
+}
+
+// wrap answers requests from the cache where possible.
+func (c *Cache) wrap(handler Handler) Handler {
+	if c == nil {
+		return handler
+	}
+
+	return func(ctx context.Context, request *OutgoingRequest) (Response, error) {
+		if request.Method != http.MethodGet && request.Method != http.MethodHead {
+			response, err := handler(ctx, request)
+			if err == nil || response.StatusCode < http.StatusInternalServerError {
+				c.invalidate(request.URL)
+			}
+			return response, err
+		}
+
+		requestControl := cacheControl(requestHeader(request.Headers, "Cache-Control"))
+		if _, ok := requestControl["no-store"]; ok ||
+			requestHeader(request.Headers, "If-None-Match") != "" || requestHeader(request.Headers, "If-Modified-Since") != "" {
+			return handler(ctx, request)
+		}
+
+		entry := c.lookup(request)
+		if entry == nil || entry.response.StatusCode != request.expectedStatusCode {
+			requestTime := time.Now()
+			response, err := handler(ctx, request)
+			if err == nil {
+				c.store(request, response, requestTime)
+			}
+			return response, err
+		}
+
+		now := time.Now()
+		age, freshness := entry.age(now), entry.freshness()
+		if maxAge, ok := seconds(requestControl, "max-age"); ok && maxAge < freshness {
+			freshness = maxAge
+		}
+		_, requestNoCache := requestControl["no-cache"]
+		_, responseNoCache := entry.control["no-cache"]
+		revalidate := requestNoCache || responseNoCache
+
+		if !revalidate && age < freshness {
+			return entry.serve(now), nil
+		}
+
+		staleness := age - freshness
+		if !revalidate && entry.stale("stale-while-revalidate", requestControl, staleness) {
+			c.background(ctx, handler, request, entry)
+			return entry.serve(now), nil
+		}
+
+		response, notModified, err := c.revalidate(ctx, handler, request, entry)
+		if notModified || err == nil {
+			return response, err
+		}
+
+		failed := !errors.Is(err, context.Canceled) && (response.StatusCode == 0 || response.StatusCode >= http.StatusInternalServerError)
+		if failed && entry.stale("stale-if-error", requestControl, staleness) {
+			return entry.serve(time.Now()), nil
+		}
+		return response, err
+	}
+}
@@ -0,0 +1,202 @@
+package ht2p_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/D3vl0per/ht2p"
+	"github.com/stretchr/testify/assert"
+	r "github.com/stretchr/testify/require"
+)
+
+func cacheServer(t *testing.T) (*httptest.Server, func(path string) int) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		hits[req.URL.Path]++
+		hit := hits[req.URL.Path]
+		mu.Unlock()
+
+		switch req.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/expires":
+			w.Header().Set("Expires", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store, max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if req.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/modified":
+			w.Header().Set("Cache-Control", "max-age=0")
+			w.Header().Set("Last-Modified", lastModified)
+			if req.Header.Get("If-Modified-Since") == lastModified {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			_, err := w.Write([]byte(req.Header.Get("Accept-Language") + " "))
+			assert.NoError(t, err)
+		case "/stale-while-revalidate":
+			w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		case "/stale-if-error":
+			w.Header().Set("Cache-Control", "max-age=0, stale-if-error=60")
+			w.Header().Set("ETag", `"v1"`)
+			if hit > 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		_, err := w.Write([]byte(strconv.Itoa(hit)))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits[path]
+	}
+}
+
+func TestCache(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		calls          []ht2p.Call
+		expectedBodies []string
+		expectedHits   int
+	}{
+		{
+			name:           "Fresh by max-age",
+			path:           "/fresh",
+			calls:          []ht2p.Call{{}, {}, {}},
+			expectedBodies: []string{"1", "1", "1"},
+			expectedHits:   1,
+		},
+		{
+			name:           "Fresh by Expires",
+			path:           "/expires",
+			calls:          []ht2p.Call{{}, {}},
+			expectedBodies: []string{"1", "1"},
+			expectedHits:   1,
+		},
+		{
+			name:           "no-store",
+			path:           "/no-store",
+			calls:          []ht2p.Call{{}, {}},
+			expectedBodies: []string{"1", "2"},
+			expectedHits:   2,
+		},
+		{
+			name:           "Request no-cache revalidates",
+			path:           "/fresh",
+			calls:          []ht2p.Call{{}, {Headers: map[string]string{"Cache-Control": "no-cache"}}},
+			expectedBodies: []string{"1", "2"},
+			expectedHits:   2,
+		},
+		{
+			name:           "Revalidation with ETag",
+			path:           "/etag",
+			calls:          []ht2p.Call{{}, {}, {}},
+			expectedBodies: []string{"1", "1", "1"},
+			expectedHits:   3,
+		},
+		{
+			name:           "Revalidation with Last-Modified",
+			path:           "/modified",
+			calls:          []ht2p.Call{{}, {}},
+			expectedBodies: []string{"1", "1"},
+			expectedHits:   2,
+		},
+		{
+			name: "Vary",
+			path: "/vary",
+			calls: []ht2p.Call{
+				{Headers: map[string]string{"Accept-Language": "en"}},
+				{Headers: map[string]string{"Accept-Language": "de"}},
+				{Headers: map[string]string{"Accept-Language": "en"}},
+			},
+			expectedBodies: []string{"en 1", "de 2", "en 1"},
+			expectedHits:   2,
+		},
+		{
+			name:           "Unsafe methods invalidate",
+			path:           "/fresh",
+			calls:          []ht2p.Call{{}, {Method: http.MethodPost}, {}},
+			expectedBodies: []string{"1", "2", "3"},
+			expectedHits:   3,
+		},
+		{
+			name:           "stale-if-error",
+			path:           "/stale-if-error",
+			calls:          []ht2p.Call{{}, {}},
+			expectedBodies: []string{"1", "1"},
+			expectedHits:   2,
+		},
+	}
+
+	for _, test := range tests {
+		for name, newClient := range backends {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				server, hits := cacheServer(t)
+				client := newClient(&ht2p.NetHttp{Cache: &ht2p.Cache{}})
+
+				for i, values := range test.calls {
+					values.URL = server.URL + test.path
+					response, err := client.With(values).Request()
+					r.NoError(t, err)
+					r.Equal(t, http.StatusOK, response.StatusCode)
+					r.Equal(t, test.expectedBodies[i], string(response.Body))
+				}
+				r.Equal(t, test.expectedHits, hits(test.path))
+			})
+		}
+	}
+
+	for name, newClient := range backends {
+		t.Run(name+" stale-while-revalidate", func(t *testing.T) {
+			server, hits := cacheServer(t)
+			client := newClient(&ht2p.NetHttp{Cache: &ht2p.Cache{}})
+			request := func() string {
+				response, err := client.With(ht2p.Call{URL: server.URL + "/stale-while-revalidate"}).Request()
+				r.NoError(t, err)
+				return string(response.Body)
+			}
+
+			r.Equal(t, "1", request())
+			r.Equal(t, "1", request())
+			r.Eventually(t, func() bool { return hits("/stale-while-revalidate") == 2 }, time.Second, time.Millisecond)
+			r.Eventually(t, func() bool { return request() == "2" }, time.Second, time.Millisecond)
+		})
+
+		t.Run(name+" Age and stored copies", func(t *testing.T) {
+			server, _ := cacheServer(t)
+			client := newClient(&ht2p.NetHttp{Cache: &ht2p.Cache{}})
+			request := func() ht2p.Response {
+				response, err := client.With(ht2p.Call{URL: server.URL + "/fresh"}).Request()
+				r.NoError(t, err)
+				return response
+			}
+
+			first := request()
+			first.Body[0] = 'x'
+			second := request()
+			r.Equal(t, "1", string(second.Body))
+			r.Equal(t, []string{"0"}, second.Headers["Age"])
+		})
+	}
+}
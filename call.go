@@ -0,0 +1,106 @@
+package ht2p
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/D3vl0per/crypt/compression"
+)
+
+// Call holds the values of a single request. Zero fields fall back to the
+// fields of the client it is bound to and Headers are added to the client
+// headers, the client itself is never changed by a call.
+type Call struct {
+	URL                string
+	URLParameters      map[string]string
+	Method             string
+	Body               []byte
+	BodyReader         io.Reader
+	BodyLength         int64
+	GetBody            func() (io.ReadCloser, error)
+	Headers            map[string]string
+	ExpectedStatusCode int
+	Ctx                context.Context
+	// RateLimitKey selects the RateLimiter bucket instead of the host.
+	RateLimitKey string
+}
+
+// call is a Call resolved against the client settings, it is not modified
+// after newCall returned.
+type call struct {
+	ctx                context.Context
+	url                string
+	urlParameters      map[string]string
+	method             string
+	headers            map[string]string
+	expectedStatusCode int
+	body               *requestBody
+	rateLimitKey       string
+}
+
+// resolve fills the zero fields of c from defaults. readerConsumed marks the
+// BodyReader of defaults, it is sent by one call of the client only.
+func (c Call) resolve(defaults Call, readerConsumed *atomic.Bool, compressor compression.Compressor, minSize int64) (*call, error) {
+	var consumed *atomic.Bool
+	if c.URL == "" {
+		c.URL = defaults.URL
+	}
+	if c.URLParameters == nil {
+		c.URLParameters = defaults.URLParameters
+	}
+	if c.Method == "" {
+		c.Method = defaults.Method
+	}
+	if c.Body == nil && c.BodyReader == nil && c.GetBody == nil {
+		c.Body, c.BodyReader, c.BodyLength, c.GetBody = defaults.Body, defaults.BodyReader, defaults.BodyLength, defaults.GetBody
+		consumed = readerConsumed
+	}
+	if c.ExpectedStatusCode == 0 {
+		c.ExpectedStatusCode = defaults.ExpectedStatusCode
+	}
+	if c.Ctx == nil {
+		c.Ctx = defaults.Ctx
+	}
+
+	parsedUrl, err := URIParser(c.URL, c.URLParameters)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := &call{
+		ctx:                c.Ctx,
+		url:                parsedUrl,
+		urlParameters:      c.URLParameters,
+		method:             c.Method,
+		headers:            make(map[string]string, len(defaults.Headers)+len(c.Headers)),
+		expectedStatusCode: c.ExpectedStatusCode,
+		rateLimitKey:       c.RateLimitKey,
+	}
+
+	if resolved.ctx == nil {
+		resolved.ctx = context.Background()
+	}
+
+	if resolved.method == "" {
+		resolved.method = http.MethodGet
+	}
+
+	if resolved.expectedStatusCode == 0 {
+		resolved.expectedStatusCode = http.StatusOK
+	}
+
+	for key, value := range defaults.Headers {
+		resolved.headers[key] = value
+	}
+	for key, value := range c.Headers {
+		resolved.headers[key] = value
+	}
+
+	resolved.body = newRequestBody(c.Body, c.BodyReader, c.BodyLength, c.GetBody, consumed)
+	if err := resolved.body.compress(compressor, minSize); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
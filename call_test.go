@@ -0,0 +1,91 @@
+package ht2p_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/D3vl0per/ht2p"
+	"github.com/stretchr/testify/assert"
+	r "github.com/stretchr/testify/require"
+)
+
+func TestCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+
+		w.Header().Set("X-Method", req.Method)
+		w.Header().Set("X-Client", req.Header.Get("X-Client"))
+		w.Header().Set("X-Call", req.Header.Get("X-Call"))
+		w.Header().Set("X-Query", req.URL.RawQuery)
+		if req.URL.Query().Get("status") == "created" {
+			w.WriteHeader(http.StatusCreated)
+		}
+		_, err = w.Write(body)
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+
+	parameters := map[string]string{"client": "shared"}
+	clientHeaders := map[string]string{"X-Client": "shared"}
+
+	for name, newClient := range backends {
+		t.Run(name, func(t *testing.T) {
+			client := newClient(&ht2p.NetHttp{URL: server.URL, URLParameters: parameters, Headers: clientHeaders, Body: []byte("default")})
+
+			t.Run("Client values are kept", func(t *testing.T) {
+				for i := 0; i < 2; i++ {
+					response, err := client.Request()
+					r.NoError(t, err)
+					r.Equal(t, "default", string(response.Body))
+					r.Equal(t, "client=shared", headerOf(response, "X-Query"))
+				}
+
+				r.Len(t, parameters, 1)
+				r.Len(t, clientHeaders, 1)
+			})
+
+			t.Run("Call values override the client", func(t *testing.T) {
+				response, err := client.With(ht2p.Call{
+					Method:             http.MethodPut,
+					URLParameters:      map[string]string{"status": "created"},
+					Body:               []byte("call"),
+					Headers:            map[string]string{"X-Call": "yes"},
+					ExpectedStatusCode: http.StatusCreated,
+				}).Request()
+				r.NoError(t, err)
+				r.Equal(t, http.StatusCreated, response.StatusCode)
+				r.Equal(t, "call", string(response.Body))
+				r.Equal(t, http.MethodPut, headerOf(response, "X-Method"))
+				r.Equal(t, "shared", headerOf(response, "X-Client"))
+				r.Equal(t, "yes", headerOf(response, "X-Call"))
+				r.Equal(t, "status=created", headerOf(response, "X-Query"))
+			})
+
+			t.Run("Concurrent calls", func(t *testing.T) {
+				var wg sync.WaitGroup
+				for i := 0; i < 16; i++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						value := strconv.Itoa(i)
+						response, err := client.With(ht2p.Call{
+							Method:  http.MethodPost,
+							Body:    []byte(value),
+							Headers: map[string]string{"X-Call": value},
+						}).Request()
+						assert.NoError(t, err)
+						assert.Equal(t, value, string(response.Body))
+						assert.Equal(t, value, headerOf(response, "X-Call"))
+						assert.Equal(t, "client=shared", headerOf(response, "X-Query"))
+					}(i)
+				}
+				wg.Wait()
+			})
+		})
+	}
+}
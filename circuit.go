@@ -0,0 +1,270 @@
+package ht2p
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/D3vl0per/crypt/generic"
+)
+
+type CircuitState int
+
+const (
+	// CircuitClosed lets every request through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects every request until OpenTimeout passed.
+	CircuitOpen
+	// CircuitHalfOpen lets HalfOpenProbes requests through to decide whether
+	// the host recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+const (
+	DefaultCircuitConsecutiveFailures = 5
+	DefaultCircuitWindow              = 20
+	DefaultCircuitOpenTimeout         = 30 * time.Second
+	DefaultCircuitHalfOpenProbes      = 1
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitOpenError is returned without sending the request while the circuit
+// of Host rejects requests.
+type CircuitOpenError struct {
+	Host  string
+	State CircuitState
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return generic.StrCnct([]string{"circuit breaker open [", e.Host, "]: request rejected in ", e.State.String(), " state"}...)
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CircuitBreaker keeps one circuit per host. A circuit opens after
+// ConsecutiveFailures failures in a row or, when FailureRate is set, once that
+// share of the last Window requests failed. Transport errors and 5xx statuses
+// count as failures. After OpenTimeout HalfOpenProbes requests are let through,
+// the circuit closes when all of them succeed and opens again on the first
+// failure. OnStateChange is called outside the breaker lock.
+type CircuitBreaker struct {
+	ConsecutiveFailures int
+	FailureRate         float64
+	Window              int
+	OpenTimeout         time.Duration
+	HalfOpenProbes      int
+	OnStateChange       func(host string, from, to CircuitState)
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state       CircuitState
+	consecutive int
+	outcomes    []bool
+	next        int
+	openedAt    time.Time
+	probes      int
+	succeeded   int
+}
+
+// State reports the circuit of the host of rawUrl, or of rawUrl itself when
+// it is a bare host.
+func (b *CircuitBreaker) State(rawUrl string) CircuitState {
+	host := hostKey(rawUrl)
+
+	b.mu.Lock()
+	c, ok := b.circuits[host]
+	if !ok {
+		b.mu.Unlock()
+		return CircuitClosed
+	}
+	from := c.state
+	state := b.current(c)
+	b.mu.Unlock()
+
+	b.changed(host, from, state)
+	return state
+}
+
+func hostKey(rawUrl string) string {
+	if parsedUrl, err := url.Parse(rawUrl); err == nil && parsedUrl.Host != "" {
+		return strings.ToLower(parsedUrl.Host)
+	}
+	return strings.ToLower(rawUrl)
+}
+
+func (b *CircuitBreaker) openTimeout() time.Duration {
+	if b.OpenTimeout <= 0 {
+		return DefaultCircuitOpenTimeout
+	}
+	return b.OpenTimeout
+}
+
+func (b *CircuitBreaker) halfOpenProbes() int {
+	if b.HalfOpenProbes <= 0 {
+		return DefaultCircuitHalfOpenProbes
+	}
+	return b.HalfOpenProbes
+}
+
+// current moves an open circuit to half-open once its timeout passed, b.mu
+// must be held.
+func (b *CircuitBreaker) current(c *circuit) CircuitState {
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= b.openTimeout() {
+		c.state, c.probes, c.succeeded = CircuitHalfOpen, 0, 0
+	}
+	return c.state
+}
+
+func (b *CircuitBreaker) changed(host string, from, to CircuitState) {
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(host, from, to)
+	}
+}
+
+// allow reports whether a request may be sent and whether it is a probe of a
+// half-open circuit.
+func (b *CircuitBreaker) allow(host string) (bool, error) {
+	b.mu.Lock()
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+	c, ok := b.circuits[host]
+	if !ok {
+		c = &circuit{}
+		b.circuits[host] = c
+	}
+
+	from := c.state
+	state := b.current(c)
+	var probe bool
+	var err error
+	switch {
+	case state == CircuitOpen:
+		err = &CircuitOpenError{Host: host, State: state, Until: c.openedAt.Add(b.openTimeout())}
+	case state == CircuitHalfOpen && c.probes >= b.halfOpenProbes():
+		err = &CircuitOpenError{Host: host, State: state}
+	case state == CircuitHalfOpen:
+		c.probes++
+		probe = true
+	}
+	b.mu.Unlock()
+
+	b.changed(host, from, state)
+	return probe, err
+}
+
+// record counts the outcome of a request.
+func (b *CircuitBreaker) record(host string, probe bool, response Response, err error) {
+	b.mu.Lock()
+	c := b.circuits[host]
+	from := c.state
+	failed := endpointFailed(response, err)
+
+	switch {
+	case probe != (c.state == CircuitHalfOpen):
+		// The circuit changed its state since the request was sent.
+	case probe && failed:
+		b.open(c)
+	case probe && err == nil:
+		c.succeeded++
+		if c.succeeded >= b.halfOpenProbes() {
+			c.state, c.consecutive, c.outcomes, c.next = CircuitClosed, 0, nil, 0
+		}
+	case probe:
+		// Errors the host is not to blame for free the probe.
+		c.probes--
+	case c.state == CircuitClosed && (failed || err == nil):
+		b.observe(c, failed)
+	}
+	to := c.state
+	b.mu.Unlock()
+
+	b.changed(host, from, to)
+}
+
+// observe counts the outcome of a request through a closed circuit, b.mu must
+// be held.
+func (b *CircuitBreaker) observe(c *circuit, failed bool) {
+	window := b.Window
+	if window <= 0 {
+		window = DefaultCircuitWindow
+	}
+	if len(c.outcomes) < window {
+		c.outcomes = append(c.outcomes, failed)
+	} else {
+		c.outcomes[c.next%window] = failed
+		c.next++
+	}
+
+	if !failed {
+		c.consecutive = 0
+		return
+	}
+	c.consecutive++
+
+	consecutive := b.ConsecutiveFailures
+	if consecutive <= 0 {
+		consecutive = DefaultCircuitConsecutiveFailures
+	}
+	if c.consecutive >= consecutive {
+		b.open(c)
+		return
+	}
+
+	if b.FailureRate > 0 && len(c.outcomes) == window {
+		failures := 0
+		for _, outcome := range c.outcomes {
+			if outcome {
+				failures++
+			}
+		}
+		if float64(failures) >= b.FailureRate*float64(window) {
+			b.open(c)
+		}
+	}
+}
+
+func (b *CircuitBreaker) open(c *circuit) {
+	c.state, c.openedAt = CircuitOpen, time.Now()
+}
+
+// wrap rejects requests to hosts with an open circuit before handler sends
+// them.
+func (b *CircuitBreaker) wrap(handler Handler) Handler {
+	if b == nil {
+		return handler
+	}
+
+	return func(ctx context.Context, request *OutgoingRequest) (Response, error) {
+		host := hostKey(request.URL)
+		probe, err := b.allow(host)
+		if err != nil {
+			return Response{}, err
+		}
+
+		response, err := handler(ctx, request)
+		b.record(host, probe, response, err)
+		return response, err
+	}
+}
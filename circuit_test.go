@@ -0,0 +1,124 @@
+package ht2p_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/D3vl0per/ht2p"
+	"github.com/stretchr/testify/assert"
+	r "github.com/stretchr/testify/require"
+)
+
+type toggleServer struct {
+	*httptest.Server
+	failing atomic.Bool
+	hits    atomic.Int64
+}
+
+func newToggleServer(t *testing.T) *toggleServer {
+	s := &toggleServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.hits.Add(1)
+		if s.failing.Load() || req.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, err := w.Write([]byte("flaky"))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	for name, newClient := range backends {
+		t.Run(name+" Consecutive failures", func(t *testing.T) {
+			server := newToggleServer(t)
+			server.failing.Store(true)
+
+			var mu sync.Mutex
+			var transitions []string
+			breaker := &ht2p.CircuitBreaker{
+				ConsecutiveFailures: 2,
+				OpenTimeout:         100 * time.Millisecond,
+				OnStateChange: func(host string, from, to ht2p.CircuitState) {
+					mu.Lock()
+					defer mu.Unlock()
+					transitions = append(transitions, from.String()+" -> "+to.String())
+				},
+			}
+			client := newClient(&ht2p.NetHttp{Breaker: breaker})
+			request := func(values ht2p.Call) error {
+				values.URL = server.URL
+				_, err := client.With(values).Request()
+				return err
+			}
+
+			for i := 0; i < 2; i++ {
+				r.ErrorIs(t, request(ht2p.Call{}), ht2p.ErrStatus)
+			}
+			r.Equal(t, ht2p.CircuitOpen, breaker.State(server.URL))
+
+			err := request(ht2p.Call{})
+			r.ErrorIs(t, err, ht2p.ErrCircuitOpen)
+			var openErr *ht2p.CircuitOpenError
+			r.ErrorAs(t, err, &openErr)
+			r.Equal(t, ht2p.CircuitOpen, openErr.State)
+			r.EqualValues(t, 2, server.hits.Load())
+
+			// A failed probe opens the circuit again.
+			time.Sleep(100 * time.Millisecond)
+			r.ErrorIs(t, request(ht2p.Call{}), ht2p.ErrStatus)
+			r.ErrorIs(t, request(ht2p.Call{}), ht2p.ErrCircuitOpen)
+
+			time.Sleep(100 * time.Millisecond)
+			server.failing.Store(false)
+			r.NoError(t, request(ht2p.Call{}))
+			r.Equal(t, ht2p.CircuitClosed, breaker.State(server.URL))
+
+			mu.Lock()
+			defer mu.Unlock()
+			r.Equal(t, []string{
+				"closed -> open",
+				"open -> half-open",
+				"half-open -> open",
+				"open -> half-open",
+				"half-open -> closed",
+			}, transitions)
+		})
+
+		t.Run(name+" Failure rate", func(t *testing.T) {
+			server := newToggleServer(t)
+			breaker := &ht2p.CircuitBreaker{ConsecutiveFailures: 10, FailureRate: 0.5, Window: 4}
+			client := newClient(&ht2p.NetHttp{Breaker: breaker})
+
+			for i := 0; i < 4; i++ {
+				parameters := map[string]string{}
+				if i%2 == 1 {
+					parameters["fail"] = "1"
+				}
+				_, _ = client.With(ht2p.Call{URL: server.URL, URLParameters: parameters}).Request()
+			}
+			r.Equal(t, ht2p.CircuitOpen, breaker.State(server.URL))
+		})
+
+		t.Run(name+" Failover skips open hosts", func(t *testing.T) {
+			broken := newToggleServer(t)
+			broken.failing.Store(true)
+			fast := testServer(t, 0, http.StatusOK, "fast")
+
+			client := newClient(&ht2p.NetHttp{Breaker: &ht2p.CircuitBreaker{ConsecutiveFailures: 1, OpenTimeout: time.Minute}})
+			_, err := client.Failover([]string{broken.URL, fast.URL})
+			r.NoError(t, err)
+
+			result, err := client.Failover([]string{broken.URL, fast.URL})
+			r.NoError(t, err)
+			r.Equal(t, fast.URL, result.URL)
+			r.ErrorIs(t, result.Attempts[0].Err, ht2p.ErrCircuitOpen)
+			r.EqualValues(t, 1, broken.hits.Load())
+		})
+	}
+}
@@ -2,7 +2,10 @@ package ht2p
 
 import (
 	"errors"
+	"io"
+	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/D3vl0per/crypt/generic"
 )
@@ -11,6 +14,17 @@ type Response struct {
 	Body       []byte
 	Headers    map[string][]string
 	StatusCode int
+	Attempts   int
+	Quota      *Quota
+}
+
+// StreamResponse leaves the body on the wire, the caller must close Body.
+type StreamResponse struct {
+	Body       io.ReadCloser
+	Headers    map[string][]string
+	StatusCode int
+	Attempts   int
+	Quota      *Quota
 }
 
 type HttpClient interface {
@@ -18,6 +32,16 @@ type HttpClient interface {
 	MultiRequest(urls []string) (Response, []error)
 }
 
+// ExtendedClient adds streaming and the multi URL calls to HttpClient, it is
+// implemented by NetHttp, FastHttp and the clients returned by their With.
+type ExtendedClient interface {
+	HttpClient
+	Stream() (StreamResponse, error)
+	Failover(urls []string) (FailoverResult, error)
+	FanOut(urls []string, parallelism int) Results
+	Quorum(urls []string, policy QuorumPolicy) (QuorumResult, error)
+}
+
 func URIParser(baseUrl string, parameters map[string]string) (string, error) {
 	parsedUrl, err := url.Parse(baseUrl)
 	if err != nil {
@@ -33,3 +57,26 @@ func URIParser(baseUrl string, parameters map[string]string) (string, error) {
 	}
 	return parsedUrl.String(), nil
 }
+
+func headerValue(headers map[string][]string, key string) string {
+	if values := headers[http.CanonicalHeaderKey(key)]; len(values) != 0 {
+		return values[0]
+	}
+
+	for name, values := range headers {
+		if strings.EqualFold(name, key) && len(values) != 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func deleteHeader(headers map[string][]string, keys ...string) {
+	for name := range headers {
+		for _, key := range keys {
+			if strings.EqualFold(name, key) {
+				delete(headers, name)
+			}
+		}
+	}
+}
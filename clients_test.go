@@ -70,3 +70,49 @@ func BenchmarkRequest(b *testing.B) {
 		}
 	})
 }
+
+// testClient is the client API both backends implement including With.
+type testClient interface {
+	ht2p.ExtendedClient
+	With(c ht2p.Call) ht2p.ExtendedClient
+}
+
+// backends builds a client of each backend from the settings NetHttp shares
+// with FastHttp, the tests run every case against both.
+var backends = map[string]func(settings *ht2p.NetHttp) testClient{
+	"net/http": func(settings *ht2p.NetHttp) testClient { return settings },
+	"fasthttp": func(settings *ht2p.NetHttp) testClient { return fastHttpOf(settings) },
+}
+
+func fastHttpOf(settings *ht2p.NetHttp) *ht2p.FastHttp {
+	return &ht2p.FastHttp{
+		URL:                    settings.URL,
+		URLParameters:          settings.URLParameters,
+		Method:                 settings.Method,
+		Body:                   settings.Body,
+		BodyReader:             settings.BodyReader,
+		BodyLength:             settings.BodyLength,
+		GetBody:                settings.GetBody,
+		BodyCompressor:         settings.BodyCompressor,
+		BodyCompressionMinSize: settings.BodyCompressionMinSize,
+		Headers:                settings.Headers,
+		ExpectedStatusCode:     settings.ExpectedStatusCode,
+		UserAgent:              settings.UserAgent,
+		MaxBodySize:            settings.MaxBodySize,
+		MaxDecompressedSize:    settings.MaxDecompressedSize,
+		MaxExpansionRatio:      settings.MaxExpansionRatio,
+		Ctx:                    settings.Ctx,
+		Timeouts:               settings.Timeouts,
+		Retry:                  settings.Retry,
+		Strategy:               settings.Strategy,
+		Hedge:                  settings.Hedge,
+		Middlewares:            settings.Middlewares,
+		Pool:                   settings.Pool,
+		Breaker:                settings.Breaker,
+		RateLimit:              settings.RateLimit,
+		Throttle:               settings.Throttle,
+		Bulkhead:               settings.Bulkhead,
+		Concurrency:            settings.Concurrency,
+		Cache:                  settings.Cache,
+	}
+}
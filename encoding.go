@@ -0,0 +1,235 @@
+package ht2p
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/D3vl0per/crypt/compression"
+	"github.com/D3vl0per/crypt/generic"
+)
+
+// Encoding is a content-coding offered in Accept-Encoding. Quality is the
+// q-value, zero leaves it out (q=1) and a negative value refuses the coding.
+type Encoding struct {
+	Name    string
+	Quality float64
+}
+
+// acceptEncoding renders the Accept-Encoding header value, every offered
+// coding must be decodable.
+func acceptEncoding(encodings []Encoding) (string, error) {
+	values := make([]string, 0, len(encodings))
+	for _, encoding := range encodings {
+		name := strings.ToLower(strings.TrimSpace(encoding.Name))
+		if _, ok := decompressor(name); !ok && name != "identity" && name != "*" {
+			return "", errors.New(generic.StrCnct([]string{"unsupported content encoding [encoding]: ", encoding.Name}...))
+		}
+		if encoding.Quality > 1 {
+			return "", errors.New(generic.StrCnct([]string{"invalid quality value [encoding]: ", encoding.Name}...))
+		}
+
+		switch {
+		case encoding.Quality < 0:
+			values = append(values, name+";q=0")
+		case encoding.Quality == 0 || encoding.Quality == 1:
+			values = append(values, name)
+		default:
+			quality := strconv.FormatFloat(math.Round(encoding.Quality*1000)/1000, 'f', -1, 64)
+			values = append(values, name+";q="+quality)
+		}
+	}
+	return strings.Join(values, ", "), nil
+}
+
+// acceptedEncoding reports whether every coding of a Content-Encoding header
+// was offered. Like RFC 9110 identity stays acceptable unless refused with
+// "identity;q=0" or "*;q=0".
+func acceptedEncoding(encodings []Encoding, contentEncoding string) bool {
+	accepted := func(name string) bool {
+		wildcard := name == "identity"
+		for _, encoding := range encodings {
+			switch strings.ToLower(strings.TrimSpace(encoding.Name)) {
+			case name:
+				return encoding.Quality >= 0
+			case "*":
+				wildcard = encoding.Quality >= 0
+			}
+		}
+		return wildcard
+	}
+
+	codings := contentEncodings(contentEncoding)
+	if len(codings) == 0 {
+		return accepted("identity")
+	}
+
+	for _, coding := range codings {
+		if !accepted(coding) {
+			return false
+		}
+	}
+	return true
+}
+
+func decompressor(encoding string) (compression.Compressor, bool) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return &compression.Gzip{}, true
+	case "deflate":
+		return &compression.Zlib{}, true
+	case "br":
+		brotli := &compression.Brotli{}
+		brotli.SetLevel(compression.BrotliBestSpeed)
+		return brotli, true
+	case "zstd":
+		return &compression.Zstd{}, true
+	}
+	return nil, false
+}
+
+// newCompressor copies a crypt compressor with its level, the crypt types keep
+// per instance buffers and brotli writers which are not safe to share. Flate
+// writes raw DEFLATE while the HTTP "deflate" coding is zlib, it is replaced
+// by Zlib.
+func newCompressor(compressor compression.Compressor) compression.Compressor {
+	level := compressor.GetLevel()
+	switch compressor.(type) {
+	case *compression.Gzip:
+		return &compression.Gzip{Level: level}
+	case *compression.Zlib, *compression.Flate:
+		return &compression.Zlib{Level: level}
+	case *compression.Zstd:
+		return &compression.Zstd{Level: level}
+	case *compression.Brotli:
+		brotli := &compression.Brotli{Level: level}
+		brotli.SetLevel(level)
+		return brotli
+	}
+	return compressor
+}
+
+// contentCoding maps a crypt compressor to its HTTP content-coding token,
+// the "deflate" coding is the zlib format.
+func contentCoding(compressor compression.Compressor) string {
+	switch name := compressor.GetName(); name {
+	case "zlib", "deflate":
+		return "deflate"
+	default:
+		return name
+	}
+}
+
+// contentEncodings splits a Content-Encoding header into the codings in the
+// order they were applied, identity codings are dropped.
+func contentEncodings(header string) []string {
+	var encodings []string
+	for _, encoding := range strings.Split(header, ",") {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if encoding == "" || encoding == "identity" {
+			continue
+		}
+		encodings = append(encodings, encoding)
+	}
+	return encodings
+}
+
+// bodiless reports responses that never carry a body, their Content-Encoding
+// only describes the representation that a GET would have returned.
+func bodiless(method string, statusCode int) bool {
+	return method == http.MethodHead || statusCode == http.StatusNoContent || statusCode == http.StatusNotModified
+}
+
+// decompressBody reverses every coding listed in a Content-Encoding header,
+// an empty body is returned as it is.
+func decompressBody(body []byte, contentEncoding string, limits bodyLimits) ([]byte, error) {
+	if len(body) == 0 || len(contentEncodings(contentEncoding)) == 0 {
+		return body, nil
+	}
+
+	reader, err := decompressStream(io.NopCloser(bytes.NewReader(body)), contentEncoding, limits)
+	defer reader.Close()
+	if err != nil {
+		return body, err
+	}
+
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return body, err
+	}
+	return decoded, nil
+}
+
+// decompressStream decodes a body while it is read, limits apply to the body
+// as received and to the decoded output. An empty body reads as empty.
+func decompressStream(body io.ReadCloser, contentEncoding string, limits bodyLimits) (io.ReadCloser, error) {
+	source := &limitReader{ReadCloser: body, check: limits.checkBody}
+	body = source
+
+	encodings := contentEncodings(contentEncoding)
+	if len(encodings) == 0 {
+		return body, nil
+	}
+
+	for i := len(encodings) - 1; i >= 0; i-- {
+		compressor, ok := decompressor(encodings[i])
+		if !ok {
+			return body, &DecompressionError{Encoding: encodings[i], Err: errors.New("unsupported content encoding")}
+		}
+		body = newDecompressReader(body, encodings[i], compressor)
+	}
+
+	return &limitReader{ReadCloser: body, check: func(read int64) error {
+		return limits.checkDecompressed(source.read.Load(), read)
+	}}, nil
+}
+
+// pipeBody is the read side of an encoding goroutine, closing it closes the
+// source as well.
+type pipeBody struct {
+	*io.PipeReader
+	source io.Closer
+}
+
+func newDecompressReader(source io.ReadCloser, encoding string, compressor compression.Compressor) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		buffered := bufio.NewReader(source)
+		if _, err := buffered.Peek(1); errors.Is(err, io.EOF) {
+			// An empty body has no stream header to decode.
+			writer.Close()
+			return
+		}
+
+		if err := decompress(compressor, buffered, writer); err != nil {
+			if !errors.Is(err, ErrBodyTooLarge) {
+				err = &DecompressionError{Encoding: encoding, Err: err}
+			}
+			writer.CloseWithError(err)
+			return
+		}
+		writer.Close()
+	}()
+	return &pipeBody{PipeReader: reader, source: source}
+}
+
+func (p *pipeBody) Close() error {
+	p.PipeReader.Close()
+	return p.source.Close()
+}
+
+// decompress guards against the crypt gzip and zlib decoders closing a nil
+// reader when the stream header is malformed.
+func decompress(compressor compression.Compressor, in io.Reader, out io.Writer) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = errors.New("malformed compressed stream")
+		}
+	}()
+	return compressor.DecompressStream(in, out)
+}
@@ -0,0 +1,167 @@
+package ht2p
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/D3vl0per/crypt/generic"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	netHttpClient  = "http client"
+	fastHttpClient = "fasthttp client"
+	bodyReader     = "body reader"
+)
+
+// Error categories, match them with errors.Is.
+var (
+	ErrTransport     = errors.New("transport failure")
+	ErrTimeout       = errors.New("timeout")
+	ErrDNS           = errors.New("dns lookup failure")
+	ErrTLS           = errors.New("tls failure")
+	ErrDecompression = errors.New("decompression failure")
+	ErrStatus        = errors.New("unexpected status code")
+)
+
+// MaxErrorBodySize bounds the response body kept on a StatusError.
+var MaxErrorBodySize = 4096
+
+type StatusError struct {
+	Client     string
+	StatusCode int
+	Expected   int
+	Status     string
+	Headers    map[string][]string
+	Body       []byte
+}
+
+func newStatusError(client string, expected int, status string, response Response) *StatusError {
+	if status == "" {
+		status = http.StatusText(response.StatusCode)
+	}
+
+	body := response.Body
+	if len(body) > MaxErrorBodySize {
+		body = body[:MaxErrorBodySize]
+	}
+
+	return &StatusError{
+		Client:     client,
+		StatusCode: response.StatusCode,
+		Expected:   expected,
+		Status:     status,
+		Headers:    response.Headers,
+		Body:       append([]byte(nil), body...),
+	}
+}
+
+func (e *StatusError) Error() string {
+	return generic.StrCnct([]string{
+		"expected status code mismatch [", e.Client, "]: ", strconv.Itoa(e.StatusCode), " ", e.Status,
+		" (expected ", strconv.Itoa(e.Expected), ") body: ", string(e.Body)}...)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrStatus
+}
+
+// TransportError is returned when no response could be read. Kind is one of
+// ErrTransport, ErrTimeout, ErrDNS or ErrTLS.
+type TransportError struct {
+	Client string
+	Kind   error
+	Err    error
+}
+
+func newTransportError(client string, err error) error {
+	var transportErr *TransportError
+	if err == nil || errors.As(err, &transportErr) || errors.Is(err, ErrBodyNotReplayable) || errors.Is(err, ErrBodyTooLarge) {
+		return err
+	}
+
+	return &TransportError{
+		Client: client,
+		Kind:   transportKind(err),
+		Err:    err,
+	}
+}
+
+func (e *TransportError) Error() string {
+	return generic.StrCnct([]string{"failed to send request [", e.Client, "]: ", e.Err.Error()}...)
+}
+
+func (e *TransportError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+func (e *TransportError) Timeout() bool {
+	return isTimeout(e.Err)
+}
+
+type DecompressionError struct {
+	Encoding string
+	Err      error
+}
+
+func (e *DecompressionError) Error() string {
+	return generic.StrCnct([]string{"failed to decompress response body [", e.Encoding, "]: ", e.Err.Error()}...)
+}
+
+func (e *DecompressionError) Unwrap() []error {
+	return []error{ErrDecompression, e.Err}
+}
+
+func transportKind(err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrDNS
+	}
+
+	if isTLSError(err) {
+		return ErrTLS
+	}
+
+	if isTimeout(err) {
+		return ErrTimeout
+	}
+	return ErrTransport
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var timeoutErr interface{ Timeout() bool }
+	return errors.As(err, &timeoutErr) && timeoutErr.Timeout()
+}
+
+func isTLSError(err error) bool {
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return timeoutErr.Phase == phaseTLSHandshake
+	}
+
+	var (
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+
+	return errors.Is(err, fasthttp.ErrTLSHandshakeTimeout) ||
+		errors.As(err, &recordErr) ||
+		errors.As(err, &alertErr) ||
+		errors.As(err, &verifyErr) ||
+		errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr)
+}
@@ -0,0 +1,120 @@
+package ht2p_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/D3vl0per/ht2p"
+	"github.com/stretchr/testify/assert"
+	r "github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestStatusError(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), ht2p.MaxErrorBodySize*2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Request-Id", "42")
+		w.WriteHeader(http.StatusTeapot)
+		_, err := w.Write(body)
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+
+	for name, client := range map[string]ht2p.HttpClient{
+		"net/http": &ht2p.NetHttp{URL: server.URL, Ctx: context.Background()},
+		"fasthttp": &ht2p.FastHttp{URL: server.URL},
+	} {
+		t.Run(name, func(t *testing.T) {
+			response, err := client.Request()
+			r.ErrorIs(t, err, ht2p.ErrStatus)
+			r.Len(t, response.Body, len(body))
+
+			var statusErr *ht2p.StatusError
+			r.ErrorAs(t, err, &statusErr)
+			r.Equal(t, http.StatusTeapot, statusErr.StatusCode)
+			r.Equal(t, http.StatusOK, statusErr.Expected)
+			r.Equal(t, []string{"42"}, statusErr.Headers["X-Request-Id"])
+			r.Len(t, statusErr.Body, ht2p.MaxErrorBodySize)
+			r.Contains(t, err.Error(), "expected status code mismatch")
+		})
+	}
+}
+
+func TestTransportError(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	slow := testServer(t, time.Second, http.StatusOK, "")
+	untrusted := httptest.NewUnstartedServer(http.NotFoundHandler())
+	untrusted.Config.ErrorLog = log.New(io.Discard, "", 0)
+	untrusted.StartTLS()
+	t.Cleanup(untrusted.Close)
+
+	tests := []struct {
+		name         string
+		url          string
+		timeout      time.Duration
+		expectedKind error
+	}{
+		{name: "Connection refused", url: closed.URL, timeout: 5 * time.Second, expectedKind: ht2p.ErrTransport},
+		{name: "Timeout", url: slow.URL, timeout: 50 * time.Millisecond, expectedKind: ht2p.ErrTimeout},
+		{name: "DNS", url: "http://ht2p.invalid", timeout: 5 * time.Second, expectedKind: ht2p.ErrDNS},
+		{name: "TLS", url: untrusted.URL, timeout: 5 * time.Second, expectedKind: ht2p.ErrTLS},
+	}
+
+	for _, test := range tests {
+		for name, client := range map[string]ht2p.HttpClient{
+			"net/http": &ht2p.NetHttp{
+				URL:    test.url,
+				Ctx:    context.Background(),
+				Client: http.Client{Timeout: test.timeout},
+			},
+			"fasthttp": &ht2p.FastHttp{
+				URL:    test.url,
+				Client: fasthttp.Client{ReadTimeout: test.timeout},
+			},
+		} {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				_, err := client.Request()
+				r.ErrorIs(t, err, test.expectedKind)
+
+				var transportErr *ht2p.TransportError
+				r.ErrorAs(t, err, &transportErr)
+				r.Equal(t, test.expectedKind, transportErr.Kind)
+			})
+		}
+	}
+}
+
+func TestDecompressionError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		_, err := w.Write([]byte("definitely not brotli"))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+
+	for name, client := range map[string]ht2p.ExtendedClient{
+		"net/http": &ht2p.NetHttp{URL: server.URL, Ctx: context.Background()},
+		"fasthttp": &ht2p.FastHttp{URL: server.URL},
+	} {
+		t.Run(name, func(t *testing.T) {
+			stream, err := client.Stream()
+			r.NoError(t, err)
+			defer stream.Body.Close()
+
+			_, err = io.ReadAll(stream.Body)
+			r.ErrorIs(t, err, ht2p.ErrDecompression)
+
+			var decompressionErr *ht2p.DecompressionError
+			r.ErrorAs(t, err, &decompressionErr)
+			r.Equal(t, "br", decompressionErr.Encoding)
+		})
+	}
+}
@@ -1,10 +1,14 @@
 package ht2p
 
 import (
-	"errors"
-	"net/http"
-
-	"github.com/D3vl0per/crypt/generic"
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/D3vl0per/crypt/compression"
 	"github.com/valyala/fasthttp"
 )
 
@@ -15,135 +19,450 @@ const (
 	Deflate
 	Gzip
 	Brotil
+	Zstd
 )
 
+// FastHttp sends requests with fasthttp. Its BodyReader is read by the first
+// call only and later calls use GetBody, a client shared by goroutines should
+// carry Body or GetBody instead.
 type FastHttp struct {
-	URL                string
-	URLParameters      map[string]string
-	Method             string
-	Body               []byte
-	Headers            map[string]string
-	ExpectedStatusCode int
-	Client             fasthttp.Client
-	Compressor         compressors
-	UserAgent          string
-	MaxRedirects       int
+	URL                    string
+	URLParameters          map[string]string
+	Method                 string
+	Body                   []byte
+	BodyReader             io.Reader
+	BodyLength             int64
+	GetBody                func() (io.ReadCloser, error)
+	BodyCompressor         compression.Compressor
+	BodyCompressionMinSize int64
+	Headers                map[string]string
+	ExpectedStatusCode     int
+	Client                 fasthttp.Client
+	Compressor             compressors
+	UserAgent              string
+	MaxBodySize            int64
+	MaxDecompressedSize    int64
+	MaxExpansionRatio      float64
+	Ctx                    context.Context
+	Timeouts               Timeouts
+	MaxRedirects           int
+	Retry                  *RetryPolicy
+	Strategy               MultiStrategy
+	Hedge                  *HedgePolicy
+	Middlewares            []Middleware
+	Pool                   *Pool
+	Breaker                *CircuitBreaker
+	RateLimit              *RateLimiter
+	Throttle               *QuotaThrottle
+	Bulkhead               *Bulkhead
+	Concurrency            *AdaptiveLimiter
+	Cache                  *Cache
+
+	configure          sync.Once
+	bodyReaderConsumed atomic.Bool
+}
+
+// fastCall binds per call values to a FastHttp, the client is only read.
+type fastCall struct {
+	client *FastHttp
+	values Call
+}
+
+// With returns an ExtendedClient sending the values of c with the settings of f.
+// f must not be changed while calls are running, it may be shared by any
+// number of goroutines.
+func (f *FastHttp) With(c Call) ExtendedClient {
+	return &fastCall{client: f, values: c}
 }
 
 func (f *FastHttp) Request() (Response, error) {
+	return f.With(Call{}).Request()
+}
+
+func (f *FastHttp) Stream() (StreamResponse, error) {
+	return f.With(Call{}).Stream()
+}
+
+func (f *FastHttp) MultiRequest(urls []string) (Response, []error) {
+	return f.With(Call{}).MultiRequest(urls)
+}
+
+func (f *FastHttp) Failover(urls []string) (FailoverResult, error) {
+	return f.With(Call{}).Failover(urls)
+}
+
+func (f *FastHttp) FanOut(urls []string, parallelism int) Results {
+	return f.With(Call{}).FanOut(urls, parallelism)
+}
+
+func (f *FastHttp) Quorum(urls []string, policy QuorumPolicy) (QuorumResult, error) {
+	return f.With(Call{}).Quorum(urls, policy)
+}
 
-	ff, err := defaultFastParameterSet(f)
+func (c *fastCall) Request() (Response, error) {
+	resolved, err := c.client.newCall(c.values)
 	if err != nil {
 		return Response{}, err
 	}
 
+	return c.client.retry(resolved.ctx, resolved, resolved.url, c.client.Pool)
+}
+
+// stages wraps send with the per attempt stages configured on the client,
+// they run inside the middlewares.
+func (f *FastHttp) stages(send Handler) Handler {
+	return f.Breaker.wrap(f.RateLimit.wrap(f.Throttle.wrap(f.Bulkhead.wrap(f.Concurrency.wrap(withQuota(send))))))
+}
+
+func (f *FastHttp) retry(ctx context.Context, c *call, url string, pool *Pool) (Response, error) {
+	handler := chain(f.Middlewares, f.Cache.wrap(f.stages(f.send)))
+	return f.Retry.run(ctx, c.method, func(ctx context.Context) (Response, error) {
+		return pool.attempt(ctx, c, url, handler)
+	})
+}
+
+func (f *FastHttp) newRequest(outgoing *OutgoingRequest) (*fasthttp.Request, error) {
 	request := fasthttp.AcquireRequest()
-	request.SetRequestURI(ff.URL)
+	request.SetRequestURI(outgoing.URL)
 
-	if len(ff.Headers) != 0 {
-		for key, value := range ff.Headers {
-			request.Header.Set(key, value)
-		}
+	for key, value := range outgoing.Headers {
+		request.Header.Set(key, value)
 	}
 
-	request.Header.SetMethod(ff.Method)
+	request.Header.SetMethod(outgoing.Method)
 
-	if f.Body != nil {
-		request.SetBodyRaw(f.Body)
+	switch {
+	case outgoing.Body != nil:
+		request.SetBodyRaw(outgoing.Body)
+	case outgoing.hasBody():
+		reader, length, err := outgoing.openBody()
+		if err != nil {
+			fasthttp.ReleaseRequest(request)
+			return nil, err
+		}
+		request.SetBodyStream(reader, int(length))
 	}
+	return request, nil
+}
 
+// do sends the request bounded by ctx and releases it. fasthttp cannot abort a
+// request in flight, on cancellation do returns at once and the request runs
+// to its end in the background before it is released.
+func (f *FastHttp) do(ctx context.Context, request *fasthttp.Request, stream bool) (*fasthttp.Response, error) {
 	response := fasthttp.AcquireResponse()
+	response.StreamBody = stream
 
+	release := func() {
+		fasthttp.ReleaseRequest(request)
+		fasthttp.ReleaseResponse(response)
+	}
+
+	if ctx.Err() != nil {
+		release()
+		return nil, newTransportError(fastHttpClient, context.Cause(ctx))
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		// The request timeout is kept across redirects, unlike DoDeadline.
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			release()
+			return nil, newTransportError(fastHttpClient, fasthttp.ErrTimeout)
+		}
+		request.SetTimeout(timeout)
+	}
+
+	if ctx.Done() == nil {
+		return f.finish(ctx, request, response, f.roundTrip(request, response))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f.roundTrip(request, response)
+	}()
+
+	select {
+	case err := <-done:
+		return f.finish(ctx, request, response, err)
+	case <-ctx.Done():
+		go func() {
+			<-done
+			release()
+		}()
+		return nil, newTransportError(fastHttpClient, context.Cause(ctx))
+	}
+}
+
+func (f *FastHttp) roundTrip(request *fasthttp.Request, response *fasthttp.Response) error {
 	if f.MaxRedirects != 0 {
-		err = fasthttp.DoRedirects(request, response, f.MaxRedirects)
-	} else {
-		err = f.Client.Do(request, response)
+		return f.Client.DoRedirects(request, response, f.MaxRedirects)
 	}
+	return f.Client.Do(request, response)
+}
+
+func (f *FastHttp) finish(ctx context.Context, request *fasthttp.Request, response *fasthttp.Response, err error) (*fasthttp.Response, error) {
 	fasthttp.ReleaseRequest(request)
-	defer fasthttp.ReleaseResponse(response)
 	if err != nil {
-		return Response{}, errors.New(generic.StrCnct([]string{"failed to send request [fasthttp client]: ", err.Error()}...))
+		// Report the cause of the deadline fasthttp ran into.
+		if deadline, ok := ctx.Deadline(); ok && isTimeout(err) && !time.Now().Before(deadline) {
+			<-ctx.Done()
+			err = context.Cause(ctx)
+		}
+		fasthttp.ReleaseResponse(response)
+		return nil, newTransportError(fastHttpClient, err)
+	}
+	return response, nil
+}
+
+func (f *FastHttp) send(ctx context.Context, outgoing *OutgoingRequest) (Response, error) {
+	ctx, cancel := f.Timeouts.total(ctx)
+	defer cancel()
+
+	request, err := f.newRequest(outgoing)
+	if err != nil {
+		return Response{}, err
 	}
 
+	// fasthttp buffers the whole body unless it is streamed.
+	response, err := f.do(ctx, request, f.MaxBodySize > 0)
+	if err != nil {
+		return Response{}, err
+	}
+	defer fasthttp.ReleaseResponse(response)
+
 	responseStruct := Response{
 		StatusCode: response.StatusCode(),
 		Headers:    fastHeaderToMap(&response.Header),
 	}
 
-	responseStruct.Body = response.Body()
+	limits := f.limits()
+	rawBody, err := io.ReadAll(&limitReader{ReadCloser: io.NopCloser(newFastBody(response)), check: limits.checkBody})
+	if err != nil {
+		return responseStruct, newTransportError(bodyReader, err)
+	}
+	responseStruct.Body = rawBody
 
-	if responseStruct.StatusCode != f.ExpectedStatusCode {
-		return responseStruct,
-			errors.New(
-				generic.StrCnct([]string{
-					"expected status code mismatch [fasthttp client]: ", string(response.Header.StatusMessage()),
-					" body: ", string(responseStruct.Body)}...))
+	contentEncoding := string(response.Header.ContentEncoding())
+	if contentEncoding != "" && !bodiless(outgoing.Method, responseStruct.StatusCode) {
+		body, err := decompressBody(responseStruct.Body, contentEncoding, limits)
+		if err != nil {
+			return responseStruct, err
+		}
+		// Same as net/http after transparent decompression.
+		deleteHeader(responseStruct.Headers, "Content-Encoding", "Content-Length")
+		responseStruct.Body = body
+	}
+
+	if responseStruct.StatusCode != outgoing.expectedStatusCode {
+		return responseStruct, newStatusError(fastHttpClient, outgoing.expectedStatusCode, string(response.Header.StatusMessage()), responseStruct)
 	}
 
 	return responseStruct, nil
 }
 
-func (f *FastHttp) MultiRequest(urls []string) (Response, []error) {
-	var errs []error
-	for _, url := range urls {
+func (f *FastHttp) limits() bodyLimits {
+	return bodyLimits{
+		maxBodySize:         f.MaxBodySize,
+		maxDecompressedSize: f.MaxDecompressedSize,
+		maxExpansionRatio:   f.MaxExpansionRatio,
+	}
+}
+
+// Stream returns as soon as the response headers arrived. Retry timeouts only
+// bound the wait for the headers, reading the body is limited by Ctx alone.
+func (c *fastCall) Stream() (StreamResponse, error) {
+	f := c.client
+	resolved, err := f.newCall(c.values)
+	if err != nil {
+		return StreamResponse{}, err
+	}
+	ctx := resolved.ctx
+
+	var stream StreamResponse
+	handler := chain(f.Middlewares, f.stages(func(attemptCtx context.Context, outgoing *OutgoingRequest) (Response, error) {
+		var attemptErr error
+		stream, attemptErr = f.openStream(ctx, attemptCtx, outgoing)
+		return Response{Headers: stream.Headers, StatusCode: stream.StatusCode}, attemptErr
+	}))
+	response, err := f.Retry.run(ctx, resolved.method, func(attemptCtx context.Context) (Response, error) {
+		stream = StreamResponse{}
+		response, err := f.Pool.attempt(attemptCtx, resolved, resolved.url, handler)
+		stream = streamOf(stream, response)
+		return response, err
+	})
+	stream.Attempts = response.Attempts
+	return stream, err
+}
 
-		ff := f
-		ff.URL = url
+func (f *FastHttp) openStream(ctx, attemptCtx context.Context, outgoing *OutgoingRequest) (StreamResponse, error) {
+	// The deadline of ctx is set on the connection and keeps bounding the body,
+	// attemptCtx only cancels the wait for the headers.
+	ctx, cancelTotal := f.Timeouts.total(ctx)
+	defer cancelTotal()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stop := context.AfterFunc(attemptCtx, func() { cancel(context.Cause(attemptCtx)) })
+	defer stop()
+
+	request, err := f.newRequest(outgoing)
+	if err != nil {
+		return StreamResponse{}, err
+	}
+
+	response, err := f.do(ctx, request, true)
+	if !stop() && err == nil {
+		newFastBody(response).Close()
+		err = newTransportError(fastHttpClient, context.Cause(attemptCtx))
+	}
+	if err != nil {
+		return StreamResponse{}, err
+	}
+
+	stream := StreamResponse{
+		StatusCode: response.StatusCode(),
+		Headers:    fastHeaderToMap(&response.Header),
+	}
+	statusMessage := string(response.Header.StatusMessage())
 
-		response, err := ff.Request()
+	contentEncoding := string(response.Header.ContentEncoding())
+	if bodiless(outgoing.Method, stream.StatusCode) {
+		contentEncoding = ""
+	}
+	responseBody, err := decompressStream(newFastBody(response), contentEncoding, f.limits())
+	if err != nil {
+		responseBody.Close()
+		return stream, err
+	}
+	if contentEncoding != "" {
+		deleteHeader(stream.Headers, "Content-Encoding", "Content-Length")
+	}
+
+	if stream.StatusCode != outgoing.expectedStatusCode {
+		rawBody, err := io.ReadAll(responseBody)
+		responseBody.Close()
+		stream.Body = io.NopCloser(bytes.NewReader(rawBody))
 		if err != nil {
-			errs = append(errs, err)
-			continue
-		} else {
-			return response, errs
+			return stream, newTransportError(bodyReader, err)
 		}
+		return stream, newStatusError(fastHttpClient, outgoing.expectedStatusCode, statusMessage,
+			Response{Body: rawBody, Headers: stream.Headers, StatusCode: stream.StatusCode})
 	}
-	return Response{}, errs
+
+	stream.Body = responseBody
+	return stream, nil
+}
+
+func (c *fastCall) MultiRequest(urls []string) (Response, []error) {
+	return multiErrors(c.Failover(urls))
 }
 
-func defaultFastParameterSet(f *FastHttp) (*FastHttp, error) {
-	ff := f
-	parsedUrl, err := URIParser(ff.URL, ff.URLParameters)
+func (c *fastCall) Failover(urls []string) (FailoverResult, error) {
+	resolved, err := c.client.newCall(c.values)
 	if err != nil {
-		return &FastHttp{}, err
+		return FailoverResult{}, err
 	}
 
-	ff.URL = parsedUrl
+	return multiRequest(resolved.ctx, urls, c.client.Strategy, c.client.Hedge, c.client.requestURL(resolved))
+}
 
-	if f.Method == "" {
-		ff.Method = http.MethodGet
+func (c *fastCall) FanOut(urls []string, parallelism int) Results {
+	resolved, err := c.client.newCall(c.values)
+	if err != nil {
+		return failedResults(urls, err)
 	}
 
-	if len(f.Headers) == 0 {
-		ff.Headers = make(map[string]string)
+	return fanOut(resolved.ctx, urls, parallelism, c.client.requestURL(resolved))
+}
+
+func (c *fastCall) Quorum(urls []string, policy QuorumPolicy) (QuorumResult, error) {
+	resolved, err := c.client.newCall(c.values)
+	if err != nil {
+		return QuorumResult{}, err
 	}
 
-	if f.UserAgent != "" {
-		ff.Headers["User-Agent"] = f.UserAgent
+	return quorum(resolved.ctx, urls, policy, c.client.requestURL(resolved))
+}
+
+func (f *FastHttp) requestURL(c *call) urlRequestFunc {
+	return func(ctx context.Context, rawUrl string) (Response, error) {
+		parsedUrl, err := URIParser(rawUrl, c.urlParameters)
+		if err != nil {
+			return Response{}, err
+		}
+
+		return f.retry(ctx, c, parsedUrl, nil)
 	}
+}
 
-	if f.ExpectedStatusCode == 0 {
-		ff.ExpectedStatusCode = fasthttp.StatusOK
+// newCall resolves the values of one call against the client fields without
+// changing them. The timeout dialer is installed on the first call only.
+func (f *FastHttp) newCall(values Call) (*call, error) {
+	f.configure.Do(func() {
+		if f.Timeouts.phases() {
+			f.Client.ConfigureClient = f.Timeouts.configureClient(f.Client.ConfigureClient)
+		}
+	})
+
+	c, err := values.resolve(Call{
+		URL:                f.URL,
+		URLParameters:      f.URLParameters,
+		Method:             f.Method,
+		Body:               f.Body,
+		BodyReader:         f.BodyReader,
+		BodyLength:         f.BodyLength,
+		GetBody:            f.GetBody,
+		Headers:            f.Headers,
+		ExpectedStatusCode: f.ExpectedStatusCode,
+		Ctx:                f.Ctx,
+	}, &f.bodyReaderConsumed, f.BodyCompressor, f.BodyCompressionMinSize)
+	if err != nil {
+		return nil, err
 	}
 
-	if f.Compressor == All {
-		ff.Headers["Accept-Encoding"] = "gzip, deflate, br"
-		return ff, nil
+	if f.UserAgent != "" {
+		c.headers["User-Agent"] = f.UserAgent
 	}
 
-	if f.Compressor == Brotil {
-		ff.Headers["Accept-Encoding"] = "br"
-		return ff, nil
+	switch f.Compressor {
+	case All:
+		c.headers["Accept-Encoding"] = "gzip, deflate, br, zstd"
+	case Brotil:
+		c.headers["Accept-Encoding"] = "br"
+	case Gzip:
+		c.headers["Accept-Encoding"] = "gzip"
+	case Zstd:
+		c.headers["Accept-Encoding"] = "zstd"
+	default:
+		c.headers["Accept-Encoding"] = "deflate"
 	}
+	return c, nil
+}
+
+type fastBody struct {
+	io.Reader
+	response *fasthttp.Response
+	closed   bool
+}
 
-	if f.Compressor == Gzip {
-		ff.Headers["Accept-Encoding"] = "gzip"
-		return ff, nil
+func newFastBody(response *fasthttp.Response) *fastBody {
+	body := &fastBody{response: response}
+	if stream := response.BodyStream(); stream != nil {
+		body.Reader = stream
+	} else {
+		body.Reader = bytes.NewReader(response.Body())
 	}
+	return body
+}
 
-	ff.Headers["Accept-Encoding"] = "deflate"
+func (b *fastBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
 
-	return ff, nil
+	err := b.response.CloseBodyStream()
+	fasthttp.ReleaseResponse(b.response)
+	return err
 }
 
 func fastHeaderToMap(header *fasthttp.ResponseHeader) map[string][]string {
@@ -1,10 +1,15 @@
 package ht2p_test
 
 import (
+	"bytes"
+	"context"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/D3vl0per/ht2p"
+	"github.com/stretchr/testify/assert"
 	r "github.com/stretchr/testify/require"
 	"github.com/valyala/fasthttp"
 )
@@ -109,3 +114,114 @@ func TestFastRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestFastRequestDecompression(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"compressed":true}`), 1024)
+	server := encodingServer(t, payload)
+
+	corrupt := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Encoding", req.URL.Query().Get("encoding"))
+		_, err := w.Write([]byte("garbage"))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(corrupt.Close)
+
+	tests := []struct {
+		name          string
+		url           string
+		expectedError bool
+	}{
+		{name: "Identity", url: server.URL},
+		{name: "Gzip", url: server.URL + "?encoding=gzip"},
+		{name: "Deflate", url: server.URL + "?encoding=deflate"},
+		{name: "Brotli", url: server.URL + "?encoding=br"},
+		{name: "Zstd", url: server.URL + "?encoding=zstd"},
+		{name: "Stacked", url: server.URL + "?encoding=deflate,gzip,br,zstd"},
+		{name: "Corrupt gzip", url: corrupt.URL + "?encoding=gzip", expectedError: true},
+		{name: "Unsupported", url: corrupt.URL + "?encoding=compress", expectedError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := (&ht2p.FastHttp{URL: test.url}).Request()
+			if test.expectedError {
+				r.ErrorIs(t, err, ht2p.ErrDecompression)
+				return
+			}
+
+			r.NoError(t, err)
+			r.Equal(t, payload, response.Body)
+			r.NotContains(t, response.Headers, "Content-Encoding")
+		})
+	}
+
+	t.Run("Head", func(t *testing.T) {
+		response, err := (&ht2p.FastHttp{URL: server.URL + "?encoding=gzip", Method: http.MethodHead}).Request()
+		r.NoError(t, err)
+		r.Empty(t, response.Body)
+		r.Equal(t, []string{"gzip"}, response.Headers["Content-Encoding"])
+	})
+}
+
+func TestFastContext(t *testing.T) {
+	slow := testServer(t, time.Second, http.StatusOK, "slow")
+	fast := testServer(t, 0, http.StatusOK, "fast")
+	redirect := httptest.NewServer(http.RedirectHandler(slow.URL, http.StatusFound))
+	t.Cleanup(redirect.Close)
+	redirectFast := httptest.NewServer(http.RedirectHandler(fast.URL, http.StatusFound))
+	t.Cleanup(redirectFast.Close)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name          string
+		client        *ht2p.FastHttp
+		cancelAfter   time.Duration
+		timeout       time.Duration
+		multi         []string
+		expectedError error
+	}{
+		{name: "Deadline", client: &ht2p.FastHttp{URL: slow.URL}, timeout: 50 * time.Millisecond, expectedError: ht2p.ErrTimeout},
+		{name: "Cancel in flight", client: &ht2p.FastHttp{URL: slow.URL}, cancelAfter: 50 * time.Millisecond, expectedError: context.Canceled},
+		{name: "Already cancelled", client: &ht2p.FastHttp{URL: fast.URL, Ctx: cancelled}, expectedError: context.Canceled},
+		{name: "Redirect deadline", client: &ht2p.FastHttp{URL: redirect.URL, MaxRedirects: 1}, timeout: 50 * time.Millisecond, expectedError: ht2p.ErrTimeout},
+		{name: "Redirect", client: &ht2p.FastHttp{URL: redirectFast.URL, MaxRedirects: 1}, timeout: 5 * time.Second},
+		{name: "MultiRequest deadline", client: &ht2p.FastHttp{}, timeout: 50 * time.Millisecond, multi: []string{slow.URL, slow.URL}, expectedError: ht2p.ErrTimeout},
+		{name: "MultiRequest race cancel", client: &ht2p.FastHttp{Strategy: ht2p.Race}, cancelAfter: 50 * time.Millisecond, multi: []string{slow.URL, slow.URL}, expectedError: context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.client.Ctx == nil {
+				ctx, cancel := context.WithCancel(context.Background())
+				if test.timeout != 0 {
+					ctx, cancel = context.WithTimeout(context.Background(), test.timeout)
+				}
+				t.Cleanup(cancel)
+				if test.cancelAfter != 0 {
+					time.AfterFunc(test.cancelAfter, cancel)
+				}
+				test.client.Ctx = ctx
+			}
+
+			start := time.Now()
+			var err error
+			if test.multi != nil {
+				var errs []error
+				_, errs = test.client.MultiRequest(test.multi)
+				r.Len(t, errs, len(test.multi))
+				err = errs[0]
+			} else {
+				_, err = test.client.Request()
+			}
+			r.Less(t, time.Since(start), 500*time.Millisecond)
+
+			if test.expectedError == nil {
+				r.NoError(t, err)
+				return
+			}
+			r.ErrorIs(t, err, test.expectedError)
+		})
+	}
+}
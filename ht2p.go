@@ -6,174 +6,401 @@ import (
 	"errors"
 	"io"
 	"net/http"
-	"strings"
+	"sync/atomic"
 
 	"github.com/D3vl0per/crypt/compression"
-	"github.com/D3vl0per/crypt/generic"
-	"github.com/klauspost/compress/gzhttp"
 )
 
+// NetHttp sends requests with net/http. Its BodyReader is read by the first
+// call only and later calls use GetBody, a client shared by goroutines should
+// carry Body or GetBody instead.
 type NetHttp struct {
-	URL                string
-	URLParameters      map[string]string
-	Method             string
-	Body               []byte
-	Headers            map[string]string
-	ExpectedStatusCode int
-	Client             http.Client
-	Compressor         compression.Compressor
-	UserAgent          string
-	Ctx                context.Context
+	URL                    string
+	URLParameters          map[string]string
+	Method                 string
+	Body                   []byte
+	BodyReader             io.Reader
+	BodyLength             int64
+	GetBody                func() (io.ReadCloser, error)
+	BodyCompressor         compression.Compressor
+	BodyCompressionMinSize int64
+	Headers                map[string]string
+	ExpectedStatusCode     int
+	Client                 http.Client
+	Compressor             compression.Compressor
+	Encodings              []Encoding
+	StrictEncoding         bool
+	UserAgent              string
+	MaxBodySize            int64
+	MaxDecompressedSize    int64
+	MaxExpansionRatio      float64
+	Ctx                    context.Context
+	Timeouts               Timeouts
+	Retry                  *RetryPolicy
+	Strategy               MultiStrategy
+	Hedge                  *HedgePolicy
+	Middlewares            []Middleware
+	Pool                   *Pool
+	Breaker                *CircuitBreaker
+	RateLimit              *RateLimiter
+	Throttle               *QuotaThrottle
+	Bulkhead               *Bulkhead
+	Concurrency            *AdaptiveLimiter
+	Cache                  *Cache
+
+	bodyReaderConsumed atomic.Bool
+}
+
+// netCall binds per call values to a NetHttp, the client is only read.
+type netCall struct {
+	client *NetHttp
+	values Call
+}
+
+// With returns an ExtendedClient sending the values of c with the settings of n.
+// n must not be changed while calls are running, it may be shared by any
+// number of goroutines.
+func (n *NetHttp) With(c Call) ExtendedClient {
+	return &netCall{client: n, values: c}
 }
 
 func (n *NetHttp) Request() (Response, error) {
+	return n.With(Call{}).Request()
+}
+
+func (n *NetHttp) Stream() (StreamResponse, error) {
+	return n.With(Call{}).Stream()
+}
+
+func (n *NetHttp) MultiRequest(urls []string) (Response, []error) {
+	return n.With(Call{}).MultiRequest(urls)
+}
+
+func (n *NetHttp) Failover(urls []string) (FailoverResult, error) {
+	return n.With(Call{}).Failover(urls)
+}
 
-	nn, err := defaultParameterSet(n)
+func (n *NetHttp) FanOut(urls []string, parallelism int) Results {
+	return n.With(Call{}).FanOut(urls, parallelism)
+}
+
+func (n *NetHttp) Quorum(urls []string, policy QuorumPolicy) (QuorumResult, error) {
+	return n.With(Call{}).Quorum(urls, policy)
+}
+
+func (c *netCall) Request() (Response, error) {
+	resolved, err := c.client.newCall(c.values)
 	if err != nil {
 		return Response{}, err
 	}
 
-	request, err := http.NewRequestWithContext(n.Ctx, nn.Method, nn.URL, bytes.NewReader(nn.Body))
+	return c.client.retry(resolved.ctx, resolved, resolved.url, c.client.Pool)
+}
+
+// stages wraps send with the per attempt stages configured on the client,
+// they run inside the middlewares.
+func (n *NetHttp) stages(send Handler) Handler {
+	return n.Breaker.wrap(n.RateLimit.wrap(n.Throttle.wrap(n.Bulkhead.wrap(n.Concurrency.wrap(withQuota(send))))))
+}
+
+func (n *NetHttp) retry(ctx context.Context, c *call, url string, pool *Pool) (Response, error) {
+	handler := chain(n.Middlewares, n.Cache.wrap(n.stages(n.send)))
+	return n.Retry.run(ctx, c.method, func(ctx context.Context) (Response, error) {
+		return pool.attempt(ctx, c, url, handler)
+	})
+}
+
+func (n *NetHttp) newRequest(ctx context.Context, outgoing *OutgoingRequest) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, outgoing.Method, outgoing.URL, nil)
 	if err != nil {
-		return Response{}, err
+		return nil, err
 	}
 
-	if len(nn.Headers) != 0 {
-		for key, value := range nn.Headers {
-			request.Header.Set(key, value)
+	if outgoing.hasBody() {
+		reader, length, err := outgoing.openBody()
+		if err != nil {
+			return nil, err
+		}
+
+		request.Body = reader
+		request.ContentLength = length
+		if length == 0 {
+			request.Body = http.NoBody
+		}
+
+		if outgoing.replayable() {
+			request.GetBody = func() (io.ReadCloser, error) {
+				reader, _, err := outgoing.openBody()
+				return reader, err
+			}
 		}
 	}
 
-	response, err := nn.Client.Do(request)
+	for key, value := range outgoing.Headers {
+		request.Header.Set(key, value)
+	}
+	return request, nil
+}
+
+func (n *NetHttp) send(ctx context.Context, outgoing *OutgoingRequest) (Response, error) {
+	timeouts := newNetTimeouts(ctx, n.Timeouts)
+	defer timeouts.close()
+
+	request, err := n.newRequest(timeouts.ctx, outgoing)
 	if err != nil {
 		return Response{}, err
 	}
 
+	response, err := n.Client.Do(request)
+	if err != nil {
+		return Response{}, newTransportError(netHttpClient, timeouts.err(err))
+	}
+	timeouts.headers()
+	response.Body = &netTimeoutBody{ReadCloser: response.Body, timeouts: timeouts}
+	defer response.Body.Close()
+
 	responseStruct := Response{
 		Headers:    headerToMap(response.Header),
 		StatusCode: response.StatusCode,
 	}
 
-	if response.ContentLength > 0 {
-		defer response.Body.Close()
+	limits := n.limits()
+	rawBody, err := io.ReadAll(&limitReader{ReadCloser: response.Body, check: limits.checkBody})
+	if err != nil {
+		return responseStruct, newTransportError(bodyReader, err)
 	}
 
-	if response.StatusCode != nn.ExpectedStatusCode {
-		rawBody, err := io.ReadAll(response.Body)
+	responseStruct.Body = rawBody
+	contentEncoding := response.Header.Get("Content-Encoding")
+	if contentEncoding != "" && !bodiless(outgoing.Method, response.StatusCode) {
+		responseStruct.Body, err = decompressBody(rawBody, contentEncoding, limits)
 		if err != nil {
-			return responseStruct,
-				errors.New(
-					generic.StrCnct([]string{
-						"failed to read response body [body reader]: ", err.Error(),
-						" body error: ", err.Error()}...))
-		} else {
-			responseStruct.Body = rawBody
-			return responseStruct, errors.New(generic.StrCnct([]string{"expected status code mismatch [http client]"}...))
+			return responseStruct, err
 		}
+		deleteHeader(responseStruct.Headers, "Content-Encoding", "Content-Length")
 	}
 
-	rawBody, err := io.ReadAll(response.Body)
-	if err != nil {
-		return responseStruct, err
+	if response.StatusCode != outgoing.expectedStatusCode {
+		return responseStruct, newStatusError(netHttpClient, outgoing.expectedStatusCode, "", responseStruct)
 	}
 
-	if nn.Compressor == nil {
-		responseStruct.Body = rawBody
-		return responseStruct, nil
+	if len(rawBody) != 0 {
+		if err := n.checkEncoding(contentEncoding); err != nil {
+			return responseStruct, err
+		}
 	}
+	return responseStruct, nil
+}
 
-	if nn.Compressor.GetName() == "gzip" {
-		// Handled on transport level
-		responseStruct.Body = rawBody
-		return responseStruct, nil
+func (n *NetHttp) limits() bodyLimits {
+	return bodyLimits{
+		maxBodySize:         n.MaxBodySize,
+		maxDecompressedSize: n.MaxDecompressedSize,
+		maxExpansionRatio:   n.MaxExpansionRatio,
 	}
+}
 
-	if !strings.Contains(response.Header.Get("Content-Encoding"), nn.Compressor.GetName()) {
-		return responseStruct, errors.New(
-			generic.StrCnct([]string{
-				"requested decompressor mismatch by response content header: ", err.Error()}...))
+// encodings lists the offered content-codings, Compressor is offered first.
+func (n *NetHttp) encodings() []Encoding {
+	if n.Compressor == nil {
+		return n.Encodings
 	}
+	return append([]Encoding{{Name: contentCoding(n.Compressor)}}, n.Encodings...)
+}
 
-	responseStruct.Body, err = nn.Compressor.Decompress(rawBody)
+// checkEncoding rejects codings that were not offered in StrictEncoding mode,
+// otherwise any supported coding is decoded.
+func (n *NetHttp) checkEncoding(contentEncoding string) error {
+	if !n.StrictEncoding || acceptedEncoding(n.encodings(), contentEncoding) {
+		return nil
+	}
+
+	if contentEncoding == "" {
+		contentEncoding = "identity"
+	}
+	return &DecompressionError{
+		Encoding: contentEncoding,
+		Err:      errors.New("response content encoding was not offered in Accept-Encoding"),
+	}
+}
+
+// Stream returns as soon as the response headers arrived. Retry timeouts only
+// bound the wait for the headers, reading the body is limited by Ctx alone.
+func (c *netCall) Stream() (StreamResponse, error) {
+	n := c.client
+	resolved, err := n.newCall(c.values)
 	if err != nil {
-		return responseStruct, errors.New(generic.StrCnct(
-			[]string{
-				"failed to decompress response body [crypt compression]: ", err.Error()}...))
+		return StreamResponse{}, err
 	}
-	return responseStruct, nil
+	ctx := resolved.ctx
+
+	var stream StreamResponse
+	handler := chain(n.Middlewares, n.stages(func(attemptCtx context.Context, outgoing *OutgoingRequest) (Response, error) {
+		var attemptErr error
+		stream, attemptErr = n.openStream(ctx, attemptCtx, outgoing)
+		return Response{Headers: stream.Headers, StatusCode: stream.StatusCode}, attemptErr
+	}))
+	response, err := n.Retry.run(ctx, resolved.method, func(attemptCtx context.Context) (Response, error) {
+		stream = StreamResponse{}
+		response, err := n.Pool.attempt(attemptCtx, resolved, resolved.url, handler)
+		stream = streamOf(stream, response)
+		return response, err
+	})
+	stream.Attempts = response.Attempts
+	return stream, err
 }
 
-func (n *NetHttp) MultiRequest(urls []string) (Response, []error) {
-	var errs []error
-	for _, url := range urls {
+func (n *NetHttp) openStream(ctx, attemptCtx context.Context, outgoing *OutgoingRequest) (StreamResponse, error) {
+	timeouts := newNetTimeouts(ctx, n.Timeouts)
+	stop := context.AfterFunc(attemptCtx, func() { timeouts.cancel(nil) })
+
+	request, err := n.newRequest(timeouts.ctx, outgoing)
+	if err != nil {
+		timeouts.close()
+		return StreamResponse{}, err
+	}
+
+	response, err := n.Client.Do(request)
+	if !stop() && err == nil {
+		response.Body.Close()
+		err = attemptCtx.Err()
+	}
+	if err != nil {
+		timeouts.close()
+		return StreamResponse{}, newTransportError(netHttpClient, timeouts.err(err))
+	}
+	timeouts.headers()
+
+	stream := StreamResponse{
+		Headers:    headerToMap(response.Header),
+		StatusCode: response.StatusCode,
+	}
 
-		nn := n
-		nn.URL = url
+	contentEncoding := response.Header.Get("Content-Encoding")
+	if bodiless(outgoing.Method, response.StatusCode) {
+		contentEncoding = ""
+	}
+	responseBody, err := decompressStream(&netTimeoutBody{ReadCloser: response.Body, timeouts: timeouts}, contentEncoding, n.limits())
+	if err != nil {
+		responseBody.Close()
+		return stream, err
+	}
+	if contentEncoding != "" {
+		deleteHeader(stream.Headers, "Content-Encoding", "Content-Length")
+	}
+
+	if response.StatusCode == outgoing.expectedStatusCode && response.ContentLength != 0 {
+		if err := n.checkEncoding(contentEncoding); err != nil {
+			responseBody.Close()
+			return stream, err
+		}
+	}
 
-		response, err := nn.Request()
+	if response.StatusCode != outgoing.expectedStatusCode {
+		rawBody, err := io.ReadAll(responseBody)
+		responseBody.Close()
+		stream.Body = io.NopCloser(bytes.NewReader(rawBody))
 		if err != nil {
-			errs = append(errs, err)
-			continue
-		} else {
-			return response, errs
+			return stream, newTransportError(bodyReader, err)
 		}
+		return stream, newStatusError(netHttpClient, outgoing.expectedStatusCode, "",
+			Response{Body: rawBody, Headers: stream.Headers, StatusCode: stream.StatusCode})
 	}
-	return Response{}, errs
+
+	stream.Body = responseBody
+	return stream, nil
+}
+
+func (c *netCall) MultiRequest(urls []string) (Response, []error) {
+	return multiErrors(c.Failover(urls))
 }
 
-func defaultParameterSet(r *NetHttp) (*NetHttp, error) {
-	rr := r
-	parsedUrl, err := URIParser(r.URL, r.URLParameters)
+func (c *netCall) Failover(urls []string) (FailoverResult, error) {
+	resolved, err := c.client.newCall(c.values)
 	if err != nil {
-		return &NetHttp{}, err
+		return FailoverResult{}, err
 	}
 
-	rr.URL = parsedUrl
+	return multiRequest(resolved.ctx, urls, c.client.Strategy, c.client.Hedge, c.client.requestURL(resolved))
+}
 
-	if r.Method == "" {
-		rr.Method = http.MethodGet
+func (c *netCall) FanOut(urls []string, parallelism int) Results {
+	resolved, err := c.client.newCall(c.values)
+	if err != nil {
+		return failedResults(urls, err)
 	}
 
-	if len(r.Headers) == 0 {
-		rr.Headers = make(map[string]string)
-	}
+	return fanOut(resolved.ctx, urls, parallelism, c.client.requestURL(resolved))
+}
 
-	if r.UserAgent != "" {
-		rr.Headers["User-Agent"] = r.UserAgent
+func (c *netCall) Quorum(urls []string, policy QuorumPolicy) (QuorumResult, error) {
+	resolved, err := c.client.newCall(c.values)
+	if err != nil {
+		return QuorumResult{}, err
 	}
 
-	if r.ExpectedStatusCode == 0 {
-		rr.ExpectedStatusCode = http.StatusOK
-	}
+	return quorum(resolved.ctx, urls, policy, c.client.requestURL(resolved))
+}
 
-	if r.Compressor == nil {
-		return rr, nil
+func (n *NetHttp) requestURL(c *call) urlRequestFunc {
+	return func(ctx context.Context, rawUrl string) (Response, error) {
+		parsedUrl, err := URIParser(rawUrl, c.urlParameters)
+		if err != nil {
+			return Response{}, err
+		}
+
+		return n.retry(ctx, c, parsedUrl, nil)
 	}
+}
 
-	compressorName := r.Compressor.GetName()
-	rr.Headers["Accept-Encoding"] = compressorName
+// newCall resolves the values of one call against the client fields without
+// changing them.
+func (n *NetHttp) newCall(values Call) (*call, error) {
+	c, err := values.resolve(Call{
+		URL:                n.URL,
+		URLParameters:      n.URLParameters,
+		Method:             n.Method,
+		Body:               n.Body,
+		BodyReader:         n.BodyReader,
+		BodyLength:         n.BodyLength,
+		GetBody:            n.GetBody,
+		Headers:            n.Headers,
+		ExpectedStatusCode: n.ExpectedStatusCode,
+		Ctx:                n.Ctx,
+	}, &n.bodyReaderConsumed, n.BodyCompressor, n.BodyCompressionMinSize)
+	if err != nil {
+		return nil, err
+	}
 
-	if compressorName == "br" {
-		rr.Compressor.SetLevel(compression.BrotliBestSpeed)
-		return rr, nil
+	if n.UserAgent != "" {
+		c.headers["User-Agent"] = n.UserAgent
 	}
 
-	rr.Compressor.SetLevel(compression.BestSpeed)
-	if rr.Client.Transport == nil {
-		rr.Client.Transport = &http.Transport{
-			DisableCompression: false,
+	encodings := n.encodings()
+	if len(encodings) == 0 && transparentGzip(&n.Client) {
+		// Decode gzip here instead of the transport so the body limits see
+		// the compressed size.
+		if _, ok := c.headers["Accept-Encoding"]; !ok {
+			c.headers["Accept-Encoding"] = "gzip"
 		}
-
 	}
 
-	transport, ok := rr.Client.Transport.(*http.Transport)
-	if !ok {
-		return &NetHttp{}, errors.New("failed to cast transport to http.Transport [http client]")
+	if len(encodings) != 0 {
+		acceptEncoding, err := acceptEncoding(encodings)
+		if err != nil {
+			return nil, err
+		}
+		c.headers["Accept-Encoding"] = acceptEncoding
 	}
+	return c, nil
+}
 
-	transport.DisableCompression = false
-	rr.Client.Transport = gzhttp.Transport(transport, gzhttp.TransportEnableGzip(true))
-	return rr, nil
+func transparentGzip(client *http.Client) bool {
+	transport, ok := client.Transport.(*http.Transport)
+	if client.Transport == nil {
+		transport, ok = http.DefaultTransport.(*http.Transport)
+	}
+	return ok && !transport.DisableCompression
 }
 
 func headerToMap(header http.Header) map[string][]string {
@@ -1,8 +1,10 @@
 package ht2p_test
 
 import (
+	"bytes"
 	"context"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/D3vl0per/crypt/compression"
@@ -119,3 +121,109 @@ func TestRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestRequestDecompression(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"compressed":true}`), 1024)
+	server := encodingServer(t, payload)
+
+	var acceptEncoding string
+	negotiating := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		acceptEncoding = req.Header.Get("Accept-Encoding")
+		server.Config.Handler.ServeHTTP(w, req)
+	}))
+	t.Cleanup(negotiating.Close)
+
+	tests := []struct {
+		name                   string
+		encoding               string
+		compressor             compression.Compressor
+		encodings              []ht2p.Encoding
+		strict                 bool
+		expectedAcceptEncoding string
+		expectedError          bool
+	}{
+		{name: "Identity", expectedAcceptEncoding: "gzip"},
+		{name: "Gzip", encoding: "gzip", compressor: &compression.Gzip{}, expectedAcceptEncoding: "gzip"},
+		{name: "Zlib", encoding: "deflate", compressor: &compression.Zlib{}, expectedAcceptEncoding: "deflate"},
+		{name: "Zstd", encoding: "zstd", compressor: &compression.Zstd{}, expectedAcceptEncoding: "zstd"},
+		{name: "Brotli", encoding: "br", compressor: &compression.Brotli{}, expectedAcceptEncoding: "br"},
+		{
+			name:                   "Quality values",
+			encoding:               "br",
+			encodings:              []ht2p.Encoding{{Name: "br"}, {Name: "gzip", Quality: 0.8}, {Name: "identity", Quality: -1}},
+			expectedAcceptEncoding: "br, gzip;q=0.8, identity;q=0",
+		},
+		{
+			name:                   "Stacked",
+			encoding:               "gzip,br",
+			encodings:              []ht2p.Encoding{{Name: "gzip"}, {Name: "br"}},
+			strict:                 true,
+			expectedAcceptEncoding: "gzip, br",
+		},
+		{
+			name:                   "Compressor with encodings",
+			encoding:               "deflate",
+			compressor:             &compression.Zstd{},
+			encodings:              []ht2p.Encoding{{Name: "deflate", Quality: 0.5}},
+			strict:                 true,
+			expectedAcceptEncoding: "zstd, deflate;q=0.5",
+		},
+		{name: "Fallback to identity", compressor: &compression.Zstd{}, expectedAcceptEncoding: "zstd"},
+		{name: "Fallback to other coding", encoding: "gzip", compressor: &compression.Zstd{}, expectedAcceptEncoding: "zstd"},
+		{name: "Strict identity allowed", compressor: &compression.Zstd{}, strict: true, expectedAcceptEncoding: "zstd"},
+		{
+			name:                   "Strict identity refused",
+			encodings:              []ht2p.Encoding{{Name: "zstd"}, {Name: "*", Quality: -1}},
+			strict:                 true,
+			expectedAcceptEncoding: "zstd, *;q=0",
+			expectedError:          true,
+		},
+		{
+			name:                   "Strict mismatch",
+			encoding:               "gzip",
+			compressor:             &compression.Zstd{},
+			strict:                 true,
+			expectedAcceptEncoding: "zstd",
+			expectedError:          true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &ht2p.NetHttp{
+				URL:            negotiating.URL,
+				URLParameters:  map[string]string{},
+				Ctx:            context.Background(),
+				Compressor:     test.compressor,
+				Encodings:      test.encodings,
+				StrictEncoding: test.strict,
+			}
+			if test.encoding != "" {
+				client.URLParameters["encoding"] = test.encoding
+			}
+
+			response, err := client.Request()
+			r.Equal(t, test.expectedAcceptEncoding, acceptEncoding)
+			if test.expectedError {
+				r.ErrorIs(t, err, ht2p.ErrDecompression)
+				return
+			}
+
+			r.NoError(t, err)
+			r.Equal(t, payload, response.Body)
+			r.NotContains(t, response.Headers, "Content-Encoding")
+		})
+	}
+
+	t.Run("Head", func(t *testing.T) {
+		response, err := (&ht2p.NetHttp{URL: server.URL + "?encoding=gzip", Method: http.MethodHead, Ctx: context.Background()}).Request()
+		r.NoError(t, err)
+		r.Empty(t, response.Body)
+		r.Equal(t, []string{"gzip"}, response.Headers["Content-Encoding"])
+	})
+
+	for _, encodings := range [][]ht2p.Encoding{{{Name: "compress"}}, {{Name: "gzip", Quality: 2}}} {
+		_, err := (&ht2p.NetHttp{URL: server.URL, Ctx: context.Background(), Encodings: encodings}).Request()
+		r.Error(t, err)
+	}
+}
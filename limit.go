@@ -0,0 +1,76 @@
+package ht2p
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/D3vl0per/crypt/generic"
+)
+
+const (
+	maxBodySize         = "max body size"
+	maxDecompressedSize = "max decompressed size"
+	maxExpansionRatio   = "max expansion ratio"
+)
+
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// BodyTooLargeError is returned as soon as a response body passes one of the
+// MaxBodySize, MaxDecompressedSize or MaxExpansionRatio limits. Max is the
+// byte count allowed when the limit was hit.
+type BodyTooLargeError struct {
+	Limit string
+	Max   int64
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return generic.StrCnct([]string{"response body too large [", e.Limit, "]: exceeds ", strconv.FormatInt(e.Max, 10), " bytes"}...)
+}
+
+func (e *BodyTooLargeError) Is(target error) bool {
+	return target == ErrBodyTooLarge
+}
+
+// bodyLimits bounds the body as received on the wire and after decoding, a
+// zero value disables the limit.
+type bodyLimits struct {
+	maxBodySize         int64
+	maxDecompressedSize int64
+	maxExpansionRatio   float64
+}
+
+func (l bodyLimits) checkBody(read int64) error {
+	if l.maxBodySize > 0 && read > l.maxBodySize {
+		return &BodyTooLargeError{Limit: maxBodySize, Max: l.maxBodySize}
+	}
+	return nil
+}
+
+func (l bodyLimits) checkDecompressed(compressed, read int64) error {
+	if l.maxDecompressedSize > 0 && read > l.maxDecompressedSize {
+		return &BodyTooLargeError{Limit: maxDecompressedSize, Max: l.maxDecompressedSize}
+	}
+
+	if l.maxExpansionRatio > 0 && float64(read) > l.maxExpansionRatio*float64(compressed) {
+		return &BodyTooLargeError{Limit: maxExpansionRatio, Max: int64(l.maxExpansionRatio * float64(compressed))}
+	}
+	return nil
+}
+
+// limitReader counts the bytes read and fails once check rejects the count.
+// The wire side count is updated from the decoder goroutines.
+type limitReader struct {
+	io.ReadCloser
+	read  atomic.Int64
+	check func(read int64) error
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	n, err := l.ReadCloser.Read(p)
+	if limitErr := l.check(l.read.Add(int64(n))); limitErr != nil {
+		return n, limitErr
+	}
+	return n, err
+}
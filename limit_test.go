@@ -0,0 +1,87 @@
+package ht2p_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/D3vl0per/ht2p"
+	r "github.com/stretchr/testify/require"
+)
+
+func TestBodyLimits(t *testing.T) {
+	payload := bytes.Repeat([]byte{0}, 1<<20)
+	server := encodingServer(t, payload)
+
+	tests := []struct {
+		name                string
+		encoding            string
+		maxBodySize         int64
+		maxDecompressedSize int64
+		maxExpansionRatio   float64
+		expectedLimit       string
+	}{
+		{name: "Unlimited", encoding: "gzip"},
+		{name: "Within limits", encoding: "gzip", maxBodySize: 1 << 20, maxDecompressedSize: 1 << 20, maxExpansionRatio: 2000},
+		{name: "Body size", maxBodySize: 1 << 10, expectedLimit: "max body size"},
+		{name: "Compressed body size", encoding: "br", maxBodySize: 16, expectedLimit: "max body size"},
+		{name: "Gzip bomb", encoding: "gzip", maxDecompressedSize: 1 << 16, expectedLimit: "max decompressed size"},
+		{name: "Zlib bomb", encoding: "deflate", maxDecompressedSize: 1 << 16, expectedLimit: "max decompressed size"},
+		{name: "Brotli bomb", encoding: "br", maxDecompressedSize: 1 << 16, expectedLimit: "max decompressed size"},
+		{name: "Zstd bomb", encoding: "zstd", maxDecompressedSize: 1 << 16, expectedLimit: "max decompressed size"},
+		{name: "Stacked bomb", encoding: "gzip,br", maxDecompressedSize: 1 << 16, expectedLimit: "max decompressed size"},
+		{name: "Expansion ratio", encoding: "gzip", maxExpansionRatio: 100, expectedLimit: "max expansion ratio"},
+	}
+
+	for _, test := range tests {
+		url := server.URL
+		if test.encoding != "" {
+			url += "?encoding=" + test.encoding
+		}
+
+		for name, newClient := range backends {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				// Each call gets its own client, an aborted body closes the connection.
+				client := func() ht2p.ExtendedClient {
+					return newClient(&ht2p.NetHttp{
+						URL:                 url,
+						Ctx:                 context.Background(),
+						MaxBodySize:         test.maxBodySize,
+						MaxDecompressedSize: test.maxDecompressedSize,
+						MaxExpansionRatio:   test.maxExpansionRatio,
+					})
+				}
+
+				response, err := client().Request()
+				if test.expectedLimit == "" {
+					r.NoError(t, err)
+					r.Equal(t, payload, response.Body)
+				} else {
+					requireBodyTooLarge(t, err, test.expectedLimit)
+				}
+
+				stream, err := client().Stream()
+				r.NoError(t, err)
+				defer stream.Body.Close()
+
+				body, err := io.ReadAll(stream.Body)
+				if test.expectedLimit == "" {
+					r.NoError(t, err)
+					r.Equal(t, payload, body)
+					return
+				}
+				requireBodyTooLarge(t, err, test.expectedLimit)
+			})
+		}
+	}
+}
+
+func requireBodyTooLarge(t *testing.T, err error, limit string) {
+	t.Helper()
+	r.ErrorIs(t, err, ht2p.ErrBodyTooLarge)
+
+	var tooLargeErr *ht2p.BodyTooLargeError
+	r.ErrorAs(t, err, &tooLargeErr)
+	r.Equal(t, limit, tooLargeErr.Limit)
+}
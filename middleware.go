@@ -0,0 +1,93 @@
+package ht2p
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// OutgoingRequest is the backend independent view of one attempt that
+// middlewares may inspect and change. Body holds a buffered body and is nil
+// when the body is streamed from BodyReader, setting it replaces the body.
+type OutgoingRequest struct {
+	Method  string
+	URL     string
+	Headers map[string]string
+	Body    []byte
+
+	body               *requestBody
+	expectedStatusCode int
+	rateLimitKey       string
+}
+
+// Handler sends an OutgoingRequest. Responses of Stream calls reach the
+// middlewares without Body.
+type Handler func(ctx context.Context, request *OutgoingRequest) (Response, error)
+
+// Middleware wraps every attempt of a call, the first registered middleware
+// runs outermost.
+type Middleware func(next Handler) Handler
+
+func chain(middlewares []Middleware, handler Handler) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// newOutgoingRequest copies the call settings so middlewares never change them
+// for later attempts.
+func newOutgoingRequest(c *call, url string) *OutgoingRequest {
+	body := c.body
+	request := &OutgoingRequest{
+		Method:             c.method,
+		URL:                url,
+		Headers:            make(map[string]string, len(c.headers)+1),
+		body:               body,
+		expectedStatusCode: c.expectedStatusCode,
+		rateLimitKey:       c.rateLimitKey,
+	}
+	for key, value := range c.headers {
+		request.Headers[key] = value
+	}
+
+	if body != nil {
+		if body.buffered() {
+			request.Body = body.raw
+		}
+		if body.encoding != "" {
+			request.Headers["Content-Encoding"] = body.encoding
+		}
+	}
+	return request
+}
+
+// openBody returns the body for the backend request and its length, -1 when
+// unknown.
+func (r *OutgoingRequest) openBody() (io.ReadCloser, int64, error) {
+	if r.Body != nil {
+		return io.NopCloser(bytes.NewReader(r.Body)), int64(len(r.Body)), nil
+	}
+	if r.body == nil {
+		return nil, 0, nil
+	}
+	return r.body.open()
+}
+
+func (r *OutgoingRequest) hasBody() bool {
+	return r.Body != nil || r.body != nil
+}
+
+func (r *OutgoingRequest) replayable() bool {
+	return r.Body != nil || r.body == nil || r.body.replayable()
+}
+
+// streamOf applies the response a middleware returned for a Stream attempt,
+// a response that never reached the backend is served from its Body.
+func streamOf(stream StreamResponse, response Response) StreamResponse {
+	stream.Headers, stream.StatusCode, stream.Quota = response.Headers, response.StatusCode, response.Quota
+	if stream.Body == nil {
+		stream.Body = io.NopCloser(bytes.NewReader(response.Body))
+	}
+	return stream
+}
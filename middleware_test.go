@@ -0,0 +1,121 @@
+package ht2p_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/D3vl0per/ht2p"
+	"github.com/stretchr/testify/assert"
+	r "github.com/stretchr/testify/require"
+)
+
+func TestMiddlewares(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+
+		w.Header().Set("X-Authorization", req.Header.Get("Authorization"))
+		w.Header().Set("X-Path", req.URL.Path)
+		_, err = w.Write(body)
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+
+	var calls []string
+	record := func(name string) ht2p.Middleware {
+		return func(next ht2p.Handler) ht2p.Handler {
+			return func(ctx context.Context, request *ht2p.OutgoingRequest) (ht2p.Response, error) {
+				calls = append(calls, name+" "+request.Method+" "+string(request.Body))
+				response, err := next(ctx, request)
+				calls = append(calls, name+" "+http.StatusText(response.StatusCode))
+				return response, err
+			}
+		}
+	}
+	auth := func(next ht2p.Handler) ht2p.Handler {
+		return func(ctx context.Context, request *ht2p.OutgoingRequest) (ht2p.Response, error) {
+			request.Headers["Authorization"] = "Bearer token"
+			request.URL += "/v2"
+			return next(ctx, request)
+		}
+	}
+	short := func(next ht2p.Handler) ht2p.Handler {
+		return func(ctx context.Context, request *ht2p.OutgoingRequest) (ht2p.Response, error) {
+			return ht2p.Response{StatusCode: http.StatusOK, Body: []byte("cached")}, nil
+		}
+	}
+
+	tests := []struct {
+		name                  string
+		middlewares           []ht2p.Middleware
+		expectedBody          string
+		expectedAuthorization string
+		expectedPath          string
+		expectedCalls         []string
+	}{
+		{
+			name:          "Without middlewares",
+			expectedBody:  "payload",
+			expectedPath:  "/",
+			expectedCalls: nil,
+		},
+		{
+			name:                  "Order and header injection",
+			middlewares:           []ht2p.Middleware{record("outer"), auth, record("inner")},
+			expectedBody:          "payload",
+			expectedAuthorization: "Bearer token",
+			expectedPath:          "/v2",
+			expectedCalls:         []string{"outer POST payload", "inner POST payload", "inner OK", "outer OK"},
+		},
+		{
+			name:          "Short circuit",
+			middlewares:   []ht2p.Middleware{record("outer"), short, record("inner")},
+			expectedBody:  "cached",
+			expectedCalls: []string{"outer POST payload", "outer OK"},
+		},
+	}
+
+	for _, test := range tests {
+		for name, newClient := range backends {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				client := newClient(&ht2p.NetHttp{
+					URL:         server.URL,
+					Method:      http.MethodPost,
+					Body:        []byte("payload"),
+					Ctx:         context.Background(),
+					Middlewares: test.middlewares,
+				})
+
+				calls = nil
+				response, err := client.Request()
+				r.NoError(t, err)
+				r.Equal(t, test.expectedBody, string(response.Body))
+				r.Equal(t, test.expectedCalls, calls)
+				if test.expectedPath != "" {
+					r.Equal(t, test.expectedAuthorization, headerOf(response, "X-Authorization"))
+					r.Equal(t, test.expectedPath, headerOf(response, "X-Path"))
+				}
+
+				calls = nil
+				stream, err := client.Stream()
+				r.NoError(t, err)
+				defer stream.Body.Close()
+
+				body, err := io.ReadAll(stream.Body)
+				r.NoError(t, err)
+				r.Equal(t, test.expectedBody, string(body))
+				r.Equal(t, test.expectedCalls, calls)
+			})
+		}
+	}
+}
+
+func headerOf(response ht2p.Response, key string) string {
+	if values := response.Headers[key]; len(values) != 0 {
+		return values[0]
+	}
+	return ""
+}
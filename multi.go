@@ -0,0 +1,378 @@
+package ht2p
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/D3vl0per/crypt/generic"
+)
+
+type MultiStrategy int
+
+const (
+	// Sequential tries the URLs one after another until one succeeds.
+	Sequential MultiStrategy = iota
+	// Race sends the request to every URL at once, the first success wins
+	// and the remaining requests are cancelled.
+	Race
+	// Hedged starts with the first URL and only sends a duplicate to the next
+	// one when no answer arrived within the HedgePolicy delay.
+	Hedged
+)
+
+const (
+	DefaultHedgeDelay      = 100 * time.Millisecond
+	DefaultHedgeMinSamples = 20
+	DefaultHedgeWindow     = 128
+)
+
+// HedgePolicy configures the Hedged strategy. Once MinSamples latencies were
+// observed and Percentile (0..1) is set, the hedge delay follows that
+// percentile of the recent latencies instead of the fixed Delay.
+type HedgePolicy struct {
+	Delay      time.Duration
+	Percentile float64
+	MinSamples int
+	WindowSize int
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+}
+
+func (h *HedgePolicy) delay() time.Duration {
+	delay := h.Delay
+	if delay <= 0 {
+		delay = DefaultHedgeDelay
+	}
+
+	if h.Percentile <= 0 {
+		return delay
+	}
+
+	minSamples := h.MinSamples
+	if minSamples <= 0 {
+		minSamples = DefaultHedgeMinSamples
+	}
+
+	h.mu.Lock()
+	samples := append([]time.Duration(nil), h.latencies...)
+	h.mu.Unlock()
+
+	if len(samples) < minSamples {
+		return delay
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	index := int(math.Ceil(math.Min(h.Percentile, 1)*float64(len(samples)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return samples[index]
+}
+
+func (h *HedgePolicy) observe(latency time.Duration) {
+	window := h.WindowSize
+	if window <= 0 {
+		window = DefaultHedgeWindow
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.latencies) < window {
+		h.latencies = append(h.latencies, latency)
+		return
+	}
+	h.latencies[h.next%len(h.latencies)] = latency
+	h.next++
+}
+
+type urlRequestFunc func(ctx context.Context, url string) (Response, error)
+
+// FailoverResult reports a MultiRequest: Attempts lists every finished
+// request in the order they completed and Abandoned the URLs still in flight
+// when the call returned. URL is the mirror that served Response.
+type FailoverResult struct {
+	URL       string
+	Response  Response
+	Attempts  Results
+	Abandoned []string
+}
+
+// FailoverError is returned when no URL served a response, Errs holds the
+// error of every attempt.
+type FailoverError struct {
+	Errs []error
+}
+
+func (e *FailoverError) Error() string {
+	return generic.StrCnct([]string{"all urls failed [multi request]: ", strconv.Itoa(len(e.Errs)), " errors"}...)
+}
+
+func (e *FailoverError) Unwrap() []error {
+	return e.Errs
+}
+
+// multiErrors keeps the MultiRequest error list of earlier versions.
+func multiErrors(result FailoverResult, err error) (Response, []error) {
+	var failoverErr *FailoverError
+	if errors.As(err, &failoverErr) {
+		return result.Response, failoverErr.Errs
+	}
+	if err != nil {
+		return result.Response, []error{err}
+	}
+	return result.Response, result.Attempts.Errors()
+}
+
+// attempt sends one request of a MultiRequest and times it.
+func attempt(ctx context.Context, url string, request urlRequestFunc) Result {
+	start := time.Now()
+	response, err := request(ctx, url)
+	return Result{URL: url, Response: response, Err: err, Latency: time.Since(start)}
+}
+
+func multiRequest(ctx context.Context, urls []string, strategy MultiStrategy, hedge *HedgePolicy, request urlRequestFunc) (FailoverResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	switch strategy {
+	case Race:
+		return raceRequest(ctx, urls, request)
+	case Hedged:
+		return hedgedRequest(ctx, urls, hedge, request)
+	default:
+		return sequentialRequest(ctx, urls, request)
+	}
+}
+
+// served completes result with the winning attempt, or fails it with the
+// errors of all attempts.
+func served(result FailoverResult, winner *Result, errs ...error) (FailoverResult, error) {
+	if winner != nil {
+		result.URL, result.Response = winner.URL, winner.Response
+		return result, nil
+	}
+	return result, &FailoverError{Errs: append(result.Attempts.Errors(), errs...)}
+}
+
+func sequentialRequest(ctx context.Context, urls []string, request urlRequestFunc) (FailoverResult, error) {
+	var result FailoverResult
+	for _, url := range urls {
+		outcome := attempt(ctx, url, request)
+		result.Attempts = append(result.Attempts, outcome)
+		if outcome.Err == nil {
+			return served(result, &outcome)
+		}
+	}
+	return served(result, nil)
+}
+
+func raceRequest(ctx context.Context, urls []string, request urlRequestFunc) (FailoverResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outcomes := make(chan Result, len(urls))
+	for _, url := range urls {
+		go func(url string) {
+			outcomes <- attempt(ctx, url, request)
+		}(url)
+	}
+
+	var result FailoverResult
+	inFlight := append([]string(nil), urls...)
+	for range urls {
+		outcome := <-outcomes
+		result.Attempts = append(result.Attempts, outcome)
+		inFlight = removeURL(inFlight, outcome.URL)
+		if outcome.Err == nil {
+			result.Abandoned = inFlight
+			return served(result, &outcome)
+		}
+	}
+	return served(result, nil)
+}
+
+func hedgedRequest(ctx context.Context, urls []string, hedge *HedgePolicy, request urlRequestFunc) (FailoverResult, error) {
+	var result FailoverResult
+	if len(urls) == 0 {
+		return served(result, nil)
+	}
+
+	if hedge == nil {
+		hedge = &HedgePolicy{}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outcomes := make(chan Result, len(urls))
+	launched := 0
+	var inFlight []string
+	launch := func() *time.Timer {
+		url := urls[launched]
+		launched++
+		inFlight = append(inFlight, url)
+		go func() {
+			outcomes <- attempt(ctx, url, request)
+		}()
+		return time.NewTimer(hedge.delay())
+	}
+
+	timer := launch()
+	defer func() { timer.Stop() }()
+
+	for len(inFlight) > 0 {
+		select {
+		case outcome := <-outcomes:
+			result.Attempts = append(result.Attempts, outcome)
+			inFlight = removeURL(inFlight, outcome.URL)
+			if outcome.Err == nil {
+				hedge.observe(outcome.Latency)
+				result.Abandoned = inFlight
+				return served(result, &outcome)
+			}
+
+			if launched < len(urls) {
+				timer.Stop()
+				timer = launch()
+			}
+		case <-timer.C:
+			if launched < len(urls) {
+				timer = launch()
+			}
+		case <-ctx.Done():
+			result.Abandoned = inFlight
+			return served(result, nil, ctx.Err())
+		}
+	}
+	return served(result, nil)
+}
+
+// removeURL drops the first occurrence of url, the same URL may be listed
+// more than once.
+func removeURL(urls []string, url string) []string {
+	for i := range urls {
+		if urls[i] == url {
+			return append(urls[:i:i], urls[i+1:]...)
+		}
+	}
+	return urls
+}
+
+// Result is the outcome of a single endpoint in a fan-out.
+type Result struct {
+	URL      string
+	Response Response
+	Err      error
+	Latency  time.Duration
+}
+
+// Results keeps the order of the URLs passed to FanOut.
+type Results []Result
+
+func (rs Results) AllSucceeded() bool {
+	for _, result := range rs {
+		if result.Err != nil {
+			return false
+		}
+	}
+	return len(rs) != 0
+}
+
+func (rs Results) Succeeded() Results {
+	var succeeded Results
+	for _, result := range rs {
+		if result.Err == nil {
+			succeeded = append(succeeded, result)
+		}
+	}
+	return succeeded
+}
+
+func (rs Results) Errors() []error {
+	var errs []error
+	for _, result := range rs {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+	return errs
+}
+
+// Majority returns a successful result whose status code and body are shared
+// by more than half of all endpoints.
+func (rs Results) Majority() (Result, bool) {
+	for _, group := range rs.Succeeded().group(sameResponse) {
+		if len(group)*2 > len(rs) {
+			return group[0], true
+		}
+	}
+	return Result{}, false
+}
+
+func (rs Results) group(equal func(a, b Response) bool) []Results {
+	var groups []Results
+	for _, result := range rs {
+		matched := false
+		for i, group := range groups {
+			if equal(group[0].Response, result.Response) {
+				groups[i] = append(group, result)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			groups = append(groups, Results{result})
+		}
+	}
+	return groups
+}
+
+func sameResponse(a, b Response) bool {
+	return a.StatusCode == b.StatusCode && bytes.Equal(a.Body, b.Body)
+}
+
+func fanOut(ctx context.Context, urls []string, parallelism int, request urlRequestFunc) Results {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if parallelism <= 0 || parallelism > len(urls) {
+		parallelism = len(urls)
+	}
+
+	results := make(Results, len(urls))
+	semaphore := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, url string) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			results[i] = attempt(ctx, url, request)
+		}(i, url)
+	}
+	wg.Wait()
+	return results
+}
+
+func failedResults(urls []string, err error) Results {
+	results := make(Results, len(urls))
+	for i, url := range urls {
+		results[i] = Result{URL: url, Err: err}
+	}
+	return results
+}
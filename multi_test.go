@@ -0,0 +1,289 @@
+package ht2p_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/D3vl0per/ht2p"
+	"github.com/stretchr/testify/assert"
+	r "github.com/stretchr/testify/require"
+)
+
+func testServer(t *testing.T, delay time.Duration, status int, body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return
+		}
+		w.WriteHeader(status)
+		_, err := w.Write([]byte(body))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMultiRequestStrategies(t *testing.T) {
+	slow := testServer(t, 500*time.Millisecond, http.StatusOK, "slow")
+	fast := testServer(t, 0, http.StatusOK, "fast")
+	broken := testServer(t, 0, http.StatusInternalServerError, "broken")
+
+	tests := []struct {
+		name         string
+		strategy     ht2p.MultiStrategy
+		hedge        *ht2p.HedgePolicy
+		urls         []string
+		expectedBody string
+		expectedErrs int
+		maxDuration  time.Duration
+	}{
+		{
+			name:         "Sequential failover",
+			strategy:     ht2p.Sequential,
+			urls:         []string{broken.URL, fast.URL},
+			expectedBody: "fast",
+			expectedErrs: 1,
+			maxDuration:  250 * time.Millisecond,
+		},
+		{
+			name:         "Sequential waits for slow mirror",
+			strategy:     ht2p.Sequential,
+			urls:         []string{slow.URL, fast.URL},
+			expectedBody: "slow",
+		},
+		{
+			name:         "Race picks fastest mirror",
+			strategy:     ht2p.Race,
+			urls:         []string{slow.URL, fast.URL},
+			expectedBody: "fast",
+			maxDuration:  250 * time.Millisecond,
+		},
+		{
+			name:         "Race ignores failing mirror",
+			strategy:     ht2p.Race,
+			urls:         []string{broken.URL, slow.URL},
+			expectedBody: "slow",
+			expectedErrs: 1,
+		},
+		{
+			name:         "Hedged request after delay",
+			strategy:     ht2p.Hedged,
+			hedge:        &ht2p.HedgePolicy{Delay: 20 * time.Millisecond},
+			urls:         []string{slow.URL, fast.URL},
+			expectedBody: "fast",
+			maxDuration:  250 * time.Millisecond,
+		},
+		{
+			name:         "Hedged failover on error",
+			strategy:     ht2p.Hedged,
+			hedge:        &ht2p.HedgePolicy{Delay: time.Minute},
+			urls:         []string{broken.URL, fast.URL},
+			expectedBody: "fast",
+			expectedErrs: 1,
+			maxDuration:  250 * time.Millisecond,
+		},
+	}
+
+	for _, test := range tests {
+		clients := map[string]ht2p.HttpClient{
+			"net/http": &ht2p.NetHttp{Ctx: context.Background(), Strategy: test.strategy, Hedge: test.hedge},
+			"fasthttp": &ht2p.FastHttp{Strategy: test.strategy, Hedge: test.hedge},
+		}
+
+		for name, client := range clients {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				start := time.Now()
+				response, errs := client.MultiRequest(test.urls)
+
+				r.Equal(t, test.expectedBody, string(response.Body))
+				r.Len(t, errs, test.expectedErrs)
+				if test.maxDuration != 0 {
+					r.Less(t, time.Since(start), test.maxDuration)
+				}
+			})
+		}
+	}
+}
+
+func TestHedgePercentile(t *testing.T) {
+	slow := testServer(t, 500*time.Millisecond, http.StatusOK, "slow")
+	fast := testServer(t, 0, http.StatusOK, "fast")
+
+	for name, client := range map[string]ht2p.HttpClient{
+		"net/http": &ht2p.NetHttp{
+			Ctx:      context.Background(),
+			Strategy: ht2p.Hedged,
+			Hedge:    &ht2p.HedgePolicy{Delay: time.Minute, Percentile: 0.9, MinSamples: 3},
+		},
+		"fasthttp": &ht2p.FastHttp{
+			Strategy: ht2p.Hedged,
+			Hedge:    &ht2p.HedgePolicy{Delay: time.Minute, Percentile: 0.9, MinSamples: 3},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				_, errs := client.MultiRequest([]string{fast.URL})
+				r.Empty(t, errs)
+			}
+
+			start := time.Now()
+			response, errs := client.MultiRequest([]string{slow.URL, fast.URL})
+			r.Empty(t, errs)
+			r.Equal(t, "fast", string(response.Body))
+			r.Less(t, time.Since(start), 250*time.Millisecond)
+		})
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	first := testServer(t, 0, http.StatusOK, "agreed")
+	second := testServer(t, 10*time.Millisecond, http.StatusOK, "agreed")
+	broken := testServer(t, 0, http.StatusInternalServerError, "broken")
+	urls := []string{first.URL, broken.URL, second.URL}
+
+	for name, client := range map[string]ht2p.ExtendedClient{
+		"net/http": &ht2p.NetHttp{Ctx: context.Background()},
+		"fasthttp": &ht2p.FastHttp{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			results := client.FanOut(urls, 2)
+			r.Len(t, results, len(urls))
+
+			for i, result := range results {
+				r.Equal(t, urls[i], result.URL)
+				r.Positive(t, result.Latency)
+			}
+
+			r.NoError(t, results[0].Err)
+			r.Error(t, results[1].Err)
+			r.Equal(t, http.StatusInternalServerError, results[1].Response.StatusCode)
+			r.NoError(t, results[2].Err)
+
+			r.False(t, results.AllSucceeded())
+			r.Len(t, results.Succeeded(), 2)
+			r.Len(t, results.Errors(), 1)
+
+			majority, ok := results.Majority()
+			r.True(t, ok)
+			r.Equal(t, "agreed", string(majority.Response.Body))
+		})
+	}
+}
+
+func TestFanOutParallelism(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	urls := []string{server.URL, server.URL, server.URL, server.URL, server.URL, server.URL}
+
+	for name, client := range map[string]ht2p.ExtendedClient{
+		"net/http": &ht2p.NetHttp{Ctx: context.Background()},
+		"fasthttp": &ht2p.FastHttp{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mu.Lock()
+			peak = 0
+			mu.Unlock()
+
+			results := client.FanOut(urls, 2)
+			r.True(t, results.AllSucceeded())
+
+			mu.Lock()
+			defer mu.Unlock()
+			r.LessOrEqual(t, peak, 2)
+		})
+	}
+}
+
+func TestFailover(t *testing.T) {
+	slow := testServer(t, 500*time.Millisecond, http.StatusOK, "slow")
+	fast := testServer(t, 0, http.StatusOK, "fast")
+	broken := testServer(t, 0, http.StatusInternalServerError, "broken")
+
+	tests := []struct {
+		name              string
+		strategy          ht2p.MultiStrategy
+		urls              []string
+		expectedURL       string
+		expectedAttempts  []string
+		expectedStatus    []int
+		expectedAbandoned []string
+	}{
+		{
+			name:             "Sequential",
+			strategy:         ht2p.Sequential,
+			urls:             []string{broken.URL, fast.URL},
+			expectedURL:      fast.URL,
+			expectedAttempts: []string{broken.URL, fast.URL},
+			expectedStatus:   []int{http.StatusInternalServerError, http.StatusOK},
+		},
+		{
+			name:              "Race",
+			strategy:          ht2p.Race,
+			urls:              []string{slow.URL, fast.URL},
+			expectedURL:       fast.URL,
+			expectedAttempts:  []string{fast.URL},
+			expectedStatus:    []int{http.StatusOK},
+			expectedAbandoned: []string{slow.URL},
+		},
+		{
+			name:             "All failed",
+			strategy:         ht2p.Sequential,
+			urls:             []string{broken.URL, broken.URL},
+			expectedAttempts: []string{broken.URL, broken.URL},
+			expectedStatus:   []int{http.StatusInternalServerError, http.StatusInternalServerError},
+		},
+	}
+
+	for _, test := range tests {
+		clients := map[string]ht2p.ExtendedClient{
+			"net/http": &ht2p.NetHttp{Strategy: test.strategy},
+			"fasthttp": &ht2p.FastHttp{Strategy: test.strategy},
+		}
+
+		for name, client := range clients {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				result, err := client.Failover(test.urls)
+				r.Equal(t, test.expectedURL, result.URL)
+				r.Equal(t, test.expectedAbandoned, result.Abandoned)
+				r.Len(t, result.Attempts, len(test.expectedAttempts))
+				for i, attempt := range result.Attempts {
+					r.Equal(t, test.expectedAttempts[i], attempt.URL)
+					r.Equal(t, test.expectedStatus[i], attempt.Response.StatusCode)
+					r.Positive(t, attempt.Latency)
+					r.Equal(t, attempt.Response.StatusCode != http.StatusOK, attempt.Err != nil)
+				}
+
+				if test.expectedURL == "" {
+					var failoverErr *ht2p.FailoverError
+					r.ErrorAs(t, err, &failoverErr)
+					r.Len(t, failoverErr.Errs, len(test.expectedAttempts))
+					r.ErrorIs(t, err, ht2p.ErrStatus)
+					return
+				}
+				r.NoError(t, err)
+				r.Equal(t, "fast", string(result.Response.Body))
+			})
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package ht2p
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Balancing int
+
+const (
+	// RoundRobin walks the healthy endpoints in order.
+	RoundRobin Balancing = iota
+	// Random picks a healthy endpoint uniformly.
+	Random
+	// Weighted splits the calls by Endpoint.Weight with a smooth weighted
+	// round-robin, a canary with weight 1 next to one with 9 gets every tenth
+	// call.
+	Weighted
+	// LeastOutstanding picks the endpoint with the fewest calls in flight.
+	LeastOutstanding
+	// LowestLatency picks the endpoint with the lowest moving average
+	// latency, endpoints without samples are tried first.
+	LowestLatency
+)
+
+const (
+	DefaultPoolFailureThreshold = 3
+	DefaultPoolCoolDown         = 30 * time.Second
+	// latencyDecay is the weight of a new sample in the latency average.
+	latencyDecay = 0.3
+)
+
+var ErrNoEndpoint = errors.New("endpoint pool is empty")
+
+type Endpoint struct {
+	URL    string
+	Weight int
+}
+
+// Pool balances the calls of one or more clients over Endpoints, its URL
+// replaces the client URL. Endpoints failing FailureThreshold times in a row
+// with a transport error or a 5xx status are ejected for CoolDown, after it a
+// single failure ejects them again. With every endpoint ejected the pool
+// sticks to the last one that answered. A Pool is safe for concurrent use,
+// Endpoints must not be changed after the first call.
+type Pool struct {
+	Endpoints        []Endpoint
+	Balancing        Balancing
+	FailureThreshold int
+	CoolDown         time.Duration
+
+	mu     sync.Mutex
+	states []*endpointState
+	next   int
+	last   *endpointState
+}
+
+type endpointState struct {
+	Endpoint
+	outstanding  int
+	latency      time.Duration
+	failures     int
+	ejectedUntil time.Time
+	current      int
+}
+
+// EndpointStats is a snapshot of the health of an endpoint.
+type EndpointStats struct {
+	URL          string
+	Healthy      bool
+	Outstanding  int
+	Latency      time.Duration
+	Failures     int
+	EjectedUntil time.Time
+}
+
+func (p *Pool) Stats() []EndpointStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	stats := make([]EndpointStats, 0, len(p.endpoints()))
+	for _, state := range p.endpoints() {
+		stats = append(stats, EndpointStats{
+			URL:          state.URL,
+			Healthy:      !now.Before(state.ejectedUntil),
+			Outstanding:  state.outstanding,
+			Latency:      state.latency,
+			Failures:     state.failures,
+			EjectedUntil: state.ejectedUntil,
+		})
+	}
+	return stats
+}
+
+// endpoints builds the state of Endpoints on first use, p.mu must be held.
+func (p *Pool) endpoints() []*endpointState {
+	if p.states == nil {
+		p.states = make([]*endpointState, 0, len(p.Endpoints))
+		for _, endpoint := range p.Endpoints {
+			if endpoint.Weight <= 0 {
+				endpoint.Weight = 1
+			}
+			p.states = append(p.states, &endpointState{Endpoint: endpoint})
+		}
+	}
+	return p.states
+}
+
+func (p *Pool) acquire() (*endpointState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	states := p.endpoints()
+	if len(states) == 0 {
+		return nil, ErrNoEndpoint
+	}
+
+	now := time.Now()
+	healthy := make([]*endpointState, 0, len(states))
+	for _, state := range states {
+		if !now.Before(state.ejectedUntil) {
+			healthy = append(healthy, state)
+		}
+	}
+
+	var state *endpointState
+	switch {
+	case len(healthy) == 0 && p.last != nil:
+		state = p.last
+	case len(healthy) == 0:
+		state = states[0]
+		for _, candidate := range states[1:] {
+			if candidate.ejectedUntil.Before(state.ejectedUntil) {
+				state = candidate
+			}
+		}
+	default:
+		state = p.pick(healthy)
+	}
+
+	state.outstanding++
+	return state, nil
+}
+
+func (p *Pool) pick(healthy []*endpointState) *endpointState {
+	switch p.Balancing {
+	case Random:
+		return healthy[rand.Intn(len(healthy))]
+	case Weighted:
+		total := 0
+		var best *endpointState
+		for _, state := range healthy {
+			state.current += state.Weight
+			total += state.Weight
+			if best == nil || state.current > best.current {
+				best = state
+			}
+		}
+		best.current -= total
+		return best
+	case LeastOutstanding:
+		best := healthy[(p.next)%len(healthy)]
+		for _, state := range healthy {
+			if state.outstanding < best.outstanding {
+				best = state
+			}
+		}
+		p.next++
+		return best
+	case LowestLatency:
+		best := healthy[0]
+		for _, state := range healthy[1:] {
+			if state.latency < best.latency {
+				best = state
+			}
+		}
+		return best
+	default:
+		state := healthy[p.next%len(healthy)]
+		p.next++
+		return state
+	}
+}
+
+func (p *Pool) release(state *endpointState, latency time.Duration, response Response, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state.outstanding--
+	if err == nil {
+		if state.latency == 0 {
+			state.latency = latency
+		} else {
+			state.latency += time.Duration(latencyDecay * float64(latency-state.latency))
+		}
+		state.failures = 0
+		state.ejectedUntil = time.Time{}
+		p.last = state
+		return
+	}
+
+	// Errors the endpoint is not to blame for leave its health as it is.
+	if !endpointFailed(response, err) {
+		return
+	}
+
+	threshold := p.FailureThreshold
+	if threshold <= 0 {
+		threshold = DefaultPoolFailureThreshold
+	}
+
+	coolDown := p.CoolDown
+	if coolDown <= 0 {
+		coolDown = DefaultPoolCoolDown
+	}
+
+	state.failures++
+	if state.failures >= threshold {
+		state.ejectedUntil = time.Now().Add(coolDown)
+		// One more failure after the cool-down ejects the endpoint again.
+		state.failures = threshold - 1
+	}
+}
+
+func endpointFailed(response Response, err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var transportErr *TransportError
+	return response.StatusCode >= http.StatusInternalServerError || errors.As(err, &transportErr)
+}
+
+// attempt sends one attempt of c to the next endpoint, url is used without a
+// pool. Stream calls leave the pool once the response headers arrived.
+func (p *Pool) attempt(ctx context.Context, c *call, url string, handler Handler) (Response, error) {
+	if p == nil {
+		return handler(ctx, newOutgoingRequest(c, url))
+	}
+
+	state, err := p.acquire()
+	if err != nil {
+		return Response{}, err
+	}
+
+	url, err = URIParser(state.URL, c.urlParameters)
+	if err != nil {
+		p.release(state, 0, Response{}, err)
+		return Response{}, err
+	}
+
+	start := time.Now()
+	response, err := handler(ctx, newOutgoingRequest(c, url))
+	p.release(state, time.Since(start), response, err)
+	return response, err
+}
@@ -0,0 +1,166 @@
+package ht2p_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/D3vl0per/ht2p"
+	"github.com/stretchr/testify/assert"
+	r "github.com/stretchr/testify/require"
+)
+
+func TestPoolBalancing(t *testing.T) {
+	first := testServer(t, 0, http.StatusOK, "first")
+	second := testServer(t, 0, http.StatusOK, "second")
+	slow := testServer(t, 50*time.Millisecond, http.StatusOK, "slow")
+
+	tests := []struct {
+		name          string
+		balancing     ht2p.Balancing
+		endpoints     []ht2p.Endpoint
+		calls         int
+		expectedCalls map[string]int
+	}{
+		{
+			name:          "Round robin",
+			balancing:     ht2p.RoundRobin,
+			endpoints:     []ht2p.Endpoint{{URL: first.URL}, {URL: second.URL}},
+			calls:         4,
+			expectedCalls: map[string]int{"first": 2, "second": 2},
+		},
+		{
+			name:          "Weighted canary",
+			balancing:     ht2p.Weighted,
+			endpoints:     []ht2p.Endpoint{{URL: first.URL, Weight: 9}, {URL: second.URL, Weight: 1}},
+			calls:         10,
+			expectedCalls: map[string]int{"first": 9, "second": 1},
+		},
+		{
+			name:          "Lowest latency",
+			balancing:     ht2p.LowestLatency,
+			endpoints:     []ht2p.Endpoint{{URL: slow.URL}, {URL: first.URL}},
+			calls:         6,
+			expectedCalls: map[string]int{"slow": 1, "first": 5},
+		},
+	}
+
+	for _, test := range tests {
+		for name, newClient := range backends {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				client := newClient(&ht2p.NetHttp{Pool: &ht2p.Pool{Endpoints: test.endpoints, Balancing: test.balancing}})
+
+				calls := make(map[string]int)
+				for i := 0; i < test.calls; i++ {
+					response, err := client.Request()
+					r.NoError(t, err)
+					calls[string(response.Body)]++
+				}
+				r.Equal(t, test.expectedCalls, calls)
+			})
+		}
+	}
+}
+
+func TestPoolLeastOutstanding(t *testing.T) {
+	slow := testServer(t, 300*time.Millisecond, http.StatusOK, "slow")
+	fast := testServer(t, 0, http.StatusOK, "fast")
+
+	pool := &ht2p.Pool{
+		Endpoints: []ht2p.Endpoint{{URL: slow.URL}, {URL: fast.URL}},
+		Balancing: ht2p.LeastOutstanding,
+	}
+	client := &ht2p.NetHttp{Pool: pool}
+
+	done := make(chan ht2p.Response, 1)
+	go func() {
+		response, err := client.Request()
+		assert.NoError(t, err)
+		done <- response
+	}()
+
+	r.Eventually(t, func() bool { return pool.Stats()[0].Outstanding == 1 }, time.Second, time.Millisecond)
+	for i := 0; i < 3; i++ {
+		response, err := client.Request()
+		r.NoError(t, err)
+		r.Equal(t, "fast", string(response.Body))
+	}
+	r.Equal(t, "slow", string((<-done).Body))
+	r.Zero(t, pool.Stats()[0].Outstanding)
+}
+
+func TestPoolHealth(t *testing.T) {
+	var failing atomic.Bool
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, err := w.Write([]byte("flaky"))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(flaky.Close)
+	broken := testServer(t, 0, http.StatusInternalServerError, "broken")
+
+	for name, newClient := range backends {
+		t.Run(name, func(t *testing.T) {
+			failing.Store(false)
+			pool := &ht2p.Pool{
+				Endpoints:        []ht2p.Endpoint{{URL: flaky.URL}, {URL: broken.URL}},
+				FailureThreshold: 2,
+				CoolDown:         time.Minute,
+			}
+			client := newClient(&ht2p.NetHttp{Pool: pool})
+
+			for i := 0; i < 4; i++ {
+				_, _ = client.Request()
+			}
+			stats := pool.Stats()
+			r.True(t, stats[0].Healthy)
+			r.False(t, stats[1].Healthy)
+
+			// Ejected endpoints get no calls during the cool-down.
+			for i := 0; i < 3; i++ {
+				response, err := client.Request()
+				r.NoError(t, err)
+				r.Equal(t, "flaky", string(response.Body))
+			}
+
+			failing.Store(true)
+			for i := 0; i < 2; i++ {
+				_, err := client.Request()
+				r.ErrorIs(t, err, ht2p.ErrStatus)
+			}
+			r.False(t, pool.Stats()[0].Healthy)
+
+			// With every endpoint ejected the last healthy one is kept.
+			failing.Store(false)
+			response, err := client.Request()
+			r.NoError(t, err)
+			r.Equal(t, "flaky", string(response.Body))
+			r.True(t, pool.Stats()[0].Healthy)
+		})
+	}
+
+	t.Run("Empty pool", func(t *testing.T) {
+		_, err := (&ht2p.NetHttp{Pool: &ht2p.Pool{}}).Request()
+		r.ErrorIs(t, err, ht2p.ErrNoEndpoint)
+	})
+}
+
+func TestPoolRetry(t *testing.T) {
+	broken := testServer(t, 0, http.StatusServiceUnavailable, "broken")
+	fast := testServer(t, 0, http.StatusOK, "fast")
+
+	pool := &ht2p.Pool{Endpoints: []ht2p.Endpoint{{URL: broken.URL}, {URL: fast.URL}}}
+	client := &ht2p.FastHttp{
+		Pool:  pool,
+		Retry: &ht2p.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+	}
+
+	response, err := client.Request()
+	r.NoError(t, err)
+	r.Equal(t, "fast", string(response.Body))
+	r.Equal(t, 2, response.Attempts)
+}
@@ -0,0 +1,90 @@
+package ht2p
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/D3vl0per/crypt/generic"
+)
+
+// QuorumPolicy asks Replicas endpoints (all URLs when zero) and succeeds when
+// at least Required of them (a majority when zero) return equal responses.
+// Equal defaults to comparing status codes and bodies byte by byte.
+type QuorumPolicy struct {
+	Replicas int
+	Required int
+	Equal    func(a, b Response) bool
+}
+
+type QuorumResult struct {
+	Response  Response
+	Agreed    Results
+	Disagreed Results
+	Failed    Results
+}
+
+type QuorumError struct {
+	Required int
+	Agreed   int
+	Replicas int
+}
+
+func (e *QuorumError) Error() string {
+	return generic.StrCnct([]string{
+		"quorum not reached [quorum]: ", strconv.Itoa(e.Agreed), " of ", strconv.Itoa(e.Replicas),
+		" replicas agreed, ", strconv.Itoa(e.Required), " required"}...)
+}
+
+func quorum(ctx context.Context, urls []string, policy QuorumPolicy, request urlRequestFunc) (QuorumResult, error) {
+	replicas := policy.Replicas
+	if replicas <= 0 || replicas > len(urls) {
+		replicas = len(urls)
+	}
+
+	required := policy.Required
+	if required <= 0 {
+		required = replicas/2 + 1
+	}
+
+	if replicas == 0 || required > replicas {
+		return QuorumResult{}, errors.New(generic.StrCnct([]string{
+			"invalid quorum policy [quorum]: ", strconv.Itoa(required), " of ", strconv.Itoa(replicas), " replicas required"}...))
+	}
+
+	equal := policy.Equal
+	if equal == nil {
+		equal = sameResponse
+	}
+
+	results := fanOut(ctx, urls[:replicas], replicas, request)
+
+	var result QuorumResult
+	for _, failed := range results {
+		if failed.Err != nil {
+			result.Failed = append(result.Failed, failed)
+		}
+	}
+
+	for _, group := range results.Succeeded().group(equal) {
+		if len(group) > len(result.Agreed) {
+			if result.Agreed != nil {
+				result.Disagreed = append(result.Disagreed, result.Agreed...)
+			}
+			result.Agreed = group
+			continue
+		}
+		result.Disagreed = append(result.Disagreed, group...)
+	}
+
+	if len(result.Agreed) < required {
+		return result, &QuorumError{
+			Required: required,
+			Agreed:   len(result.Agreed),
+			Replicas: replicas,
+		}
+	}
+
+	result.Response = result.Agreed[0].Response
+	return result, nil
+}
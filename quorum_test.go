@@ -0,0 +1,102 @@
+package ht2p_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/D3vl0per/ht2p"
+	r "github.com/stretchr/testify/require"
+)
+
+func TestQuorum(t *testing.T) {
+	current := testServer(t, 0, http.StatusOK, `{"version":2}`)
+	replica := testServer(t, 0, http.StatusOK, `{"version":2}`)
+	stale := testServer(t, 0, http.StatusOK, `{"version":1}`)
+	staleSpaced := testServer(t, 0, http.StatusOK, `{"version": 1}`)
+	broken := testServer(t, 0, http.StatusInternalServerError, "")
+
+	ignoreSpaces := func(a, b ht2p.Response) bool {
+		return bytes.Equal(bytes.ReplaceAll(a.Body, []byte(" "), nil), bytes.ReplaceAll(b.Body, []byte(" "), nil))
+	}
+
+	tests := []struct {
+		name              string
+		urls              []string
+		policy            ht2p.QuorumPolicy
+		expectedBody      string
+		expectedDisagreed []string
+		expectedFailed    []string
+		expectedError     bool
+	}{
+		{
+			name:              "Majority agrees",
+			urls:              []string{current.URL, stale.URL, replica.URL},
+			expectedBody:      `{"version":2}`,
+			expectedDisagreed: []string{stale.URL},
+		},
+		{
+			name:           "Quorum despite failed replica",
+			urls:           []string{current.URL, broken.URL, replica.URL},
+			policy:         ht2p.QuorumPolicy{Required: 2},
+			expectedBody:   `{"version":2}`,
+			expectedFailed: []string{broken.URL},
+		},
+		{
+			name:              "Split brain",
+			urls:              []string{current.URL, stale.URL, broken.URL},
+			expectedDisagreed: []string{stale.URL},
+			expectedFailed:    []string{broken.URL},
+			expectedError:     true,
+		},
+		{
+			name:              "Only first replicas are asked",
+			urls:              []string{current.URL, replica.URL, stale.URL},
+			policy:            ht2p.QuorumPolicy{Replicas: 2, Required: 2},
+			expectedBody:      `{"version":2}`,
+			expectedDisagreed: nil,
+		},
+		{
+			name:              "Custom comparator",
+			urls:              []string{stale.URL, staleSpaced.URL, current.URL},
+			policy:            ht2p.QuorumPolicy{Required: 2, Equal: ignoreSpaces},
+			expectedBody:      `{"version":1}`,
+			expectedDisagreed: []string{current.URL},
+		},
+		{
+			name:          "Invalid policy",
+			urls:          []string{current.URL},
+			policy:        ht2p.QuorumPolicy{Required: 2},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		for name, client := range map[string]ht2p.ExtendedClient{
+			"net/http": &ht2p.NetHttp{Ctx: context.Background()},
+			"fasthttp": &ht2p.FastHttp{},
+		} {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				result, err := client.Quorum(test.urls, test.policy)
+				r.Equal(t, test.expectedDisagreed, urlsOf(result.Disagreed))
+				r.Equal(t, test.expectedFailed, urlsOf(result.Failed))
+
+				if test.expectedError {
+					r.Error(t, err)
+					return
+				}
+				r.NoError(t, err)
+				r.Equal(t, test.expectedBody, string(result.Response.Body))
+			})
+		}
+	}
+}
+
+func urlsOf(results ht2p.Results) []string {
+	var urls []string
+	for _, result := range results {
+		urls = append(urls, result.URL)
+	}
+	return urls
+}
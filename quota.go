@@ -0,0 +1,168 @@
+package ht2p
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quota is the rate limit an upstream announced with the RateLimit headers of
+// the IETF draft or the X-RateLimit-* headers. Limit and Remaining are -1 when
+// not sent, Reset is zero when unknown.
+type Quota struct {
+	Limit     int64
+	Remaining int64
+	Reset     time.Time
+	Policy    string
+}
+
+// resetEpoch separates X-RateLimit-Reset given as a Unix time from a delay in
+// seconds.
+const resetEpoch = 1e9
+
+// ParseQuota reads the RateLimit structured header ("limit=100, remaining=50,
+// reset=30" or "r=50;t=30"), RateLimit-Limit/Remaining/Reset or
+// X-RateLimit-Limit/Remaining/Reset. Resets are delays in seconds relative to
+// now, large values are taken as Unix times like GitHub sends them.
+func ParseQuota(headers map[string][]string, now time.Time) (Quota, bool) {
+	quota := Quota{Limit: -1, Remaining: -1, Policy: headerValue(headers, "RateLimit-Policy")}
+
+	if value := headerValue(headers, "RateLimit"); value != "" {
+		for _, parameter := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+			key, value, _ := strings.Cut(strings.TrimSpace(parameter), "=")
+			switch strings.ToLower(key) {
+			case "limit":
+				quota.Limit = quotaNumber(value)
+			case "remaining", "r":
+				quota.Remaining = quotaNumber(value)
+			case "reset", "t":
+				quota.Reset = quotaReset(value, now)
+			}
+		}
+		if quota.Remaining >= 0 {
+			return quota, true
+		}
+	}
+
+	for _, prefix := range []string{"RateLimit-", "X-RateLimit-"} {
+		quota.Limit = quotaNumber(headerValue(headers, prefix+"Limit"))
+		quota.Remaining = quotaNumber(headerValue(headers, prefix+"Remaining"))
+		if quota.Limit < 0 && quota.Remaining < 0 {
+			continue
+		}
+		quota.Reset = quotaReset(headerValue(headers, prefix+"Reset"), now)
+		return quota, true
+	}
+	return Quota{}, false
+}
+
+// quotaNumber reads the leading number of a value like "100, 100;w=60".
+func quotaNumber(value string) int64 {
+	value, _, _ = strings.Cut(value, ",")
+	value, _, _ = strings.Cut(value, ";")
+	number, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || number < 0 {
+		return -1
+	}
+	return number
+}
+
+func quotaReset(value string, now time.Time) time.Time {
+	seconds := quotaNumber(value)
+	switch {
+	case seconds < 0:
+		return time.Time{}
+	case seconds >= resetEpoch:
+		return time.Unix(seconds, 0)
+	default:
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+}
+
+// withQuota sets Response.Quota from the response headers.
+func withQuota(handler Handler) Handler {
+	return func(ctx context.Context, request *OutgoingRequest) (Response, error) {
+		response, err := handler(ctx, request)
+		if quota, ok := ParseQuota(response.Headers, time.Now()); ok {
+			response.Quota = &quota
+		}
+		return response, err
+	}
+}
+
+const DefaultQuotaThreshold = 0.1
+
+// QuotaThrottle slows down requests to a host once the last response left
+// less than Threshold (a fraction of Limit) of its quota. The remaining
+// requests are spread evenly until the reset, with none left the next request
+// waits for the reset. Without a Limit only an exhausted quota is waited for.
+// MaxDelay caps a single wait when set.
+type QuotaThrottle struct {
+	Threshold float64
+	MaxDelay  time.Duration
+
+	mu     sync.Mutex
+	quotas map[string]Quota
+}
+
+func (t *QuotaThrottle) delay(host string, now time.Time) time.Duration {
+	t.mu.Lock()
+	quota, ok := t.quotas[host]
+	t.mu.Unlock()
+
+	if !ok || quota.Remaining < 0 || !quota.Reset.After(now) {
+		return 0
+	}
+
+	threshold := t.Threshold
+	if threshold <= 0 {
+		threshold = DefaultQuotaThreshold
+	}
+	switch {
+	case quota.Limit < 0 && quota.Remaining > 0:
+		return 0
+	case quota.Limit >= 0 && float64(quota.Remaining) > threshold*float64(quota.Limit):
+		return 0
+	}
+
+	delay := quota.Reset.Sub(now) / time.Duration(quota.Remaining+1)
+	if t.MaxDelay > 0 && delay > t.MaxDelay {
+		delay = t.MaxDelay
+	}
+	return delay
+}
+
+func (t *QuotaThrottle) observe(host string, quota *Quota) {
+	if quota == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.quotas == nil {
+		t.quotas = make(map[string]Quota)
+	}
+	t.quotas[host] = *quota
+}
+
+// wrap delays requests by the quota the host announced last.
+func (t *QuotaThrottle) wrap(handler Handler) Handler {
+	if t == nil {
+		return handler
+	}
+
+	return func(ctx context.Context, request *OutgoingRequest) (Response, error) {
+		host := hostKey(request.URL)
+		if delay := t.delay(host, time.Now()); delay > 0 {
+			if err := sleepContext(ctx, delay); err != nil {
+				return Response{}, &RateLimitError{Key: host, Err: err}
+			}
+		}
+
+		response, err := handler(ctx, request)
+		t.observe(host, response.Quota)
+		return response, err
+	}
+}
@@ -0,0 +1,163 @@
+package ht2p_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/D3vl0per/ht2p"
+	"github.com/stretchr/testify/assert"
+	r "github.com/stretchr/testify/require"
+)
+
+func TestParseQuota(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		headers       map[string][]string
+		expectedQuota ht2p.Quota
+		expectedOk    bool
+	}{
+		{
+			name: "Draft headers",
+			headers: map[string][]string{
+				"Ratelimit-Limit":     {"100"},
+				"Ratelimit-Remaining": {"42"},
+				"Ratelimit-Reset":     {"30"},
+				"Ratelimit-Policy":    {"100;w=60"},
+			},
+			expectedQuota: ht2p.Quota{Limit: 100, Remaining: 42, Reset: now.Add(30 * time.Second), Policy: "100;w=60"},
+			expectedOk:    true,
+		},
+		{
+			name:          "Draft limit with policy",
+			headers:       map[string][]string{"Ratelimit-Limit": {"100, 100;w=60"}, "Ratelimit-Remaining": {"1"}},
+			expectedQuota: ht2p.Quota{Limit: 100, Remaining: 1},
+			expectedOk:    true,
+		},
+		{
+			name:          "Structured header",
+			headers:       map[string][]string{"Ratelimit": {"limit=10, remaining=0, reset=5"}},
+			expectedQuota: ht2p.Quota{Limit: 10, Remaining: 0, Reset: now.Add(5 * time.Second)},
+			expectedOk:    true,
+		},
+		{
+			name:          "Structured header with short keys",
+			headers:       map[string][]string{"Ratelimit": {`"default";r=3;t=2`}},
+			expectedQuota: ht2p.Quota{Limit: -1, Remaining: 3, Reset: now.Add(2 * time.Second)},
+			expectedOk:    true,
+		},
+		{
+			name: "X-RateLimit with Unix reset",
+			headers: map[string][]string{
+				"X-Ratelimit-Limit":     {"5000"},
+				"X-Ratelimit-Remaining": {"4999"},
+				"X-Ratelimit-Reset":     {strconv.FormatInt(now.Add(time.Hour).Unix(), 10)},
+			},
+			expectedQuota: ht2p.Quota{Limit: 5000, Remaining: 4999, Reset: now.Add(time.Hour)},
+			expectedOk:    true,
+		},
+		{
+			name:          "Invalid values",
+			headers:       map[string][]string{"X-Ratelimit-Limit": {"many"}, "X-Ratelimit-Remaining": {"-1"}},
+			expectedQuota: ht2p.Quota{},
+		},
+		{
+			name:          "Without headers",
+			headers:       map[string][]string{"Content-Type": {"text/plain"}},
+			expectedQuota: ht2p.Quota{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quota, ok := ht2p.ParseQuota(test.headers, now)
+			r.Equal(t, test.expectedOk, ok)
+			r.True(t, test.expectedQuota.Reset.Equal(quota.Reset))
+			quota.Reset, test.expectedQuota.Reset = time.Time{}, time.Time{}
+			r.Equal(t, test.expectedQuota, quota)
+		})
+	}
+}
+
+func TestQuotaThrottle(t *testing.T) {
+	var remaining atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("RateLimit-Limit", "10")
+		w.Header().Set("RateLimit-Remaining", strconv.FormatInt(remaining.Load(), 10))
+		w.Header().Set("RateLimit-Reset", "1")
+		_, err := w.Write([]byte("quota"))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+
+	for name, newClient := range backends {
+		t.Run(name, func(t *testing.T) {
+			remaining.Store(5)
+			client := newClient(&ht2p.NetHttp{URL: server.URL, Throttle: &ht2p.QuotaThrottle{MaxDelay: 100 * time.Millisecond}})
+
+			response, err := client.Request()
+			r.NoError(t, err)
+			r.NotNil(t, response.Quota)
+			r.EqualValues(t, 10, response.Quota.Limit)
+			r.EqualValues(t, 5, response.Quota.Remaining)
+
+			// Half of the quota left is above the threshold.
+			start := time.Now()
+			remaining.Store(0)
+			_, err = client.Request()
+			r.NoError(t, err)
+			r.Less(t, time.Since(start), 50*time.Millisecond)
+
+			start = time.Now()
+			stream, err := client.Stream()
+			r.NoError(t, err)
+			r.NoError(t, stream.Body.Close())
+			r.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+			r.EqualValues(t, 0, stream.Quota.Remaining)
+		})
+	}
+
+	t.Run("Unknown limit", func(t *testing.T) {
+		var remaining atomic.Int64
+		unlimited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(remaining.Load(), 10))
+			w.Header().Set("X-RateLimit-Reset", "3600")
+		}))
+		t.Cleanup(unlimited.Close)
+
+		remaining.Store(4999)
+		client := &ht2p.NetHttp{URL: unlimited.URL, Throttle: &ht2p.QuotaThrottle{MaxDelay: 100 * time.Millisecond}}
+		response, err := client.Request()
+		r.NoError(t, err)
+		r.EqualValues(t, -1, response.Quota.Limit)
+
+		// A large remaining quota is not throttled without a limit.
+		start := time.Now()
+		remaining.Store(0)
+		_, err = client.Request()
+		r.NoError(t, err)
+		r.Less(t, time.Since(start), 50*time.Millisecond)
+
+		start = time.Now()
+		_, err = client.Request()
+		r.NoError(t, err)
+		r.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	})
+
+	t.Run("Without throttle", func(t *testing.T) {
+		remaining.Store(0)
+		client := &ht2p.NetHttp{URL: server.URL}
+		for i := 0; i < 2; i++ {
+			start := time.Now()
+			response, err := client.Request()
+			r.NoError(t, err)
+			r.EqualValues(t, 0, response.Quota.Remaining)
+			r.Less(t, time.Since(start), 50*time.Millisecond)
+		}
+	})
+}
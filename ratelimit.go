@@ -0,0 +1,136 @@
+package ht2p
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/D3vl0per/crypt/generic"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimitError is returned when the context expired, or would expire,
+// before a token of the bucket Key was available.
+type RateLimitError struct {
+	Key string
+	Err error
+}
+
+func (e *RateLimitError) Error() string {
+	return generic.StrCnct([]string{"rate limit wait aborted [", e.Key, "]: ", e.Err.Error()}...)
+}
+
+func (e *RateLimitError) Unwrap() []error {
+	return []error{ErrRateLimited, e.Err}
+}
+
+// RateLimiter keeps a token bucket per host, or per Call.RateLimitKey when
+// set. Every attempt takes a token and waits for it when the bucket is empty.
+// Rate is in requests per second, Burst defaults to Rate rounded up. Clients
+// sharing a RateLimiter draw from the same buckets.
+type RateLimiter struct {
+	Rate  float64
+	Burst int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (l *RateLimiter) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+// Wait blocks until a token of key is available. It fails at once when ctx
+// ends before that.
+func (l *RateLimiter) Wait(ctx context.Context, key string) error {
+	if l.Rate <= 0 {
+		return nil
+	}
+
+	delay, err := l.reserve(ctx, key)
+	if err != nil || delay <= 0 {
+		return err
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel(key)
+		return &RateLimitError{Key: key, Err: context.Cause(ctx)}
+	}
+}
+
+// reserve takes a token, possibly ahead of time, and returns the wait until
+// it is due.
+func (l *RateLimiter) reserve(ctx context.Context, key string) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst(), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst(), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+
+	var delay time.Duration
+	if b.tokens < 1 {
+		delay = time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, &RateLimitError{Key: key, Err: context.Cause(ctx)}
+	}
+	if deadline, ok := ctx.Deadline(); ok && delay > 0 && now.Add(delay).After(deadline) {
+		return 0, &RateLimitError{Key: key, Err: context.DeadlineExceeded}
+	}
+
+	b.tokens--
+	return delay, nil
+}
+
+// cancel returns the token of an abandoned wait.
+func (l *RateLimiter) cancel(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buckets[key].tokens++
+}
+
+// wrap waits for a token before every attempt.
+func (l *RateLimiter) wrap(handler Handler) Handler {
+	if l == nil {
+		return handler
+	}
+
+	return func(ctx context.Context, request *OutgoingRequest) (Response, error) {
+		key := request.rateLimitKey
+		if key == "" {
+			key = hostKey(request.URL)
+		}
+
+		if err := l.Wait(ctx, key); err != nil {
+			return Response{}, err
+		}
+		return handler(ctx, request)
+	}
+}
@@ -0,0 +1,74 @@
+package ht2p_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/D3vl0per/ht2p"
+	r "github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	server := testServer(t, 0, http.StatusOK, "limited")
+
+	for name, newClient := range backends {
+		t.Run(name+" Requests wait for tokens", func(t *testing.T) {
+			client := newClient(&ht2p.NetHttp{URL: server.URL, RateLimit: &ht2p.RateLimiter{Rate: 20, Burst: 2}})
+
+			start := time.Now()
+			for i := 0; i < 5; i++ {
+				_, err := client.Request()
+				r.NoError(t, err)
+			}
+			r.GreaterOrEqual(t, time.Since(start), 140*time.Millisecond)
+		})
+
+		t.Run(name+" FanOut shares the host bucket", func(t *testing.T) {
+			client := newClient(&ht2p.NetHttp{URL: server.URL, RateLimit: &ht2p.RateLimiter{Rate: 20, Burst: 1}})
+
+			start := time.Now()
+			results := client.FanOut([]string{server.URL, server.URL, server.URL, server.URL}, 0)
+			r.True(t, results.AllSucceeded())
+			r.GreaterOrEqual(t, time.Since(start), 140*time.Millisecond)
+		})
+
+		t.Run(name+" Keys and deadlines", func(t *testing.T) {
+			client := newClient(&ht2p.NetHttp{URL: server.URL, RateLimit: &ht2p.RateLimiter{Rate: 1, Burst: 1}})
+
+			start := time.Now()
+			for _, key := range []string{"partner-a", "partner-b"} {
+				_, err := client.With(ht2p.Call{RateLimitKey: key}).Request()
+				r.NoError(t, err)
+			}
+			r.Less(t, time.Since(start), 500*time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_, err := client.With(ht2p.Call{RateLimitKey: "partner-a", Ctx: ctx}).Request()
+			r.ErrorIs(t, err, ht2p.ErrRateLimited)
+			r.ErrorIs(t, err, context.DeadlineExceeded)
+			var limitErr *ht2p.RateLimitError
+			r.ErrorAs(t, err, &limitErr)
+			r.Equal(t, "partner-a", limitErr.Key)
+			r.Less(t, time.Since(start), 500*time.Millisecond)
+		})
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	limiter := &ht2p.RateLimiter{Rate: 10, Burst: 1}
+	r.NoError(t, limiter.Wait(context.Background(), "key"))
+
+	// An abandoned wait returns its token.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	r.ErrorIs(t, limiter.Wait(ctx, "key"), context.Canceled)
+
+	start := time.Now()
+	r.NoError(t, limiter.Wait(context.Background(), "key"))
+	r.Less(t, time.Since(start), 150*time.Millisecond)
+
+	r.NoError(t, (&ht2p.RateLimiter{}).Wait(context.Background(), "unlimited"))
+}
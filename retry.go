@@ -0,0 +1,371 @@
+package ht2p
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/D3vl0per/crypt/generic"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	DefaultMaxAttempts    = 3
+	DefaultInitialBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff     = 10 * time.Second
+	DefaultBackoffFactor  = 2.0
+	DefaultMaxRetryAfter  = time.Minute
+)
+
+var DefaultRetryableStatusCodes = []int{
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// DefaultRetryableMethods are idempotent, a request with any other method may
+// already have been processed when its attempt failed.
+var DefaultRetryableMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPut,
+	http.MethodDelete,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+// DefaultThrottleStatusCodes are retried whenever the response carries a
+// Retry-After header, the wait is taken from the header instead of the backoff.
+var DefaultThrottleStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusServiceUnavailable,
+}
+
+// RetryPolicy is shared by NetHttp and FastHttp. Zero values fall back to the
+// Default* constants, except Jitter where zero disables randomization.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction (0..1) of each backoff that is randomized.
+	Jitter               float64
+	RetryableStatusCodes []int
+	// RetryableMethods lists the methods that are attempted more than once,
+	// non idempotent methods such as POST are retried only when listed here.
+	RetryableMethods []string
+	// RetryableError decides if a transport error is worth another attempt,
+	// IsRetryableError is used when nil.
+	RetryableError func(error) bool
+	// PerAttemptTimeout bounds a single attempt, Timeout bounds all attempts
+	// including the backoff sleeps between them.
+	PerAttemptTimeout time.Duration
+	Timeout           time.Duration
+	// MaxRetryAfter caps the wait requested by a Retry-After header, the
+	// policy gives up instead of waiting longer. Negative disables Retry-After.
+	MaxRetryAfter       time.Duration
+	ThrottleStatusCodes []int
+}
+
+type ThrottleError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *ThrottleError) Error() string {
+	return generic.StrCnct([]string{
+		"request throttled [retry after]: status ", strconv.Itoa(e.StatusCode),
+		", retry after ", e.RetryAfter.String(), ": ", e.Err.Error()}...)
+}
+
+func (e *ThrottleError) Unwrap() error {
+	return e.Err
+}
+
+type attemptFunc func(ctx context.Context) (Response, error)
+
+func (p *RetryPolicy) run(ctx context.Context, method string, attempt attemptFunc) (Response, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if p == nil {
+		response, err := attempt(ctx)
+		response.Attempts = 1
+		return response, p.throttled(response, err)
+	}
+
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	if !p.retryableMethod(method) {
+		maxAttempts = 1
+	}
+
+	var (
+		response Response
+		err      error
+	)
+	for i := 1; i <= maxAttempts; i++ {
+		previous, previousErr := response, err
+		response, err = p.attempt(ctx, attempt)
+		response.Attempts = i
+
+		if i > 1 && errors.Is(err, ErrBodyNotReplayable) {
+			response, err = previous, previousErr
+			break
+		}
+
+		if err == nil || i == maxAttempts {
+			break
+		}
+
+		delay, retry := p.delay(ctx, i, response, err)
+		if !retry {
+			break
+		}
+
+		if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+			break
+		}
+	}
+	return response, p.throttled(response, err)
+}
+
+func (p *RetryPolicy) delay(ctx context.Context, attempt int, response Response, err error) (time.Duration, bool) {
+	if retryAfter, ok := p.retryAfter(response); ok {
+		maxWait := p.MaxRetryAfter
+		if maxWait == 0 {
+			maxWait = DefaultMaxRetryAfter
+		}
+		if retryAfter > maxWait || ctx.Err() != nil {
+			return 0, false
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(retryAfter).After(deadline) {
+			return 0, false
+		}
+		return retryAfter, true
+	}
+
+	if !p.retryable(ctx, response, err) {
+		return 0, false
+	}
+	return p.backoff(attempt), true
+}
+
+func (p *RetryPolicy) retryAfter(response Response) (time.Duration, bool) {
+	if p.MaxRetryAfter < 0 {
+		return 0, false
+	}
+
+	if !containsStatus(p.throttleStatusCodes(), response.StatusCode) {
+		return 0, false
+	}
+	return ParseRetryAfter(response.Headers, time.Now())
+}
+
+func (p *RetryPolicy) attempt(ctx context.Context, attempt attemptFunc) (Response, error) {
+	if p.PerAttemptTimeout <= 0 {
+		return attempt(ctx)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, p.PerAttemptTimeout)
+	defer cancel()
+	return attempt(attemptCtx)
+}
+
+func (p *RetryPolicy) retryable(ctx context.Context, response Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if response.StatusCode != 0 {
+		codes := p.RetryableStatusCodes
+		if codes == nil {
+			codes = DefaultRetryableStatusCodes
+		}
+		return containsStatus(codes, response.StatusCode)
+	}
+
+	if p.RetryableError != nil {
+		return p.RetryableError(err)
+	}
+	return IsRetryableError(err)
+}
+
+func (p *RetryPolicy) retryableMethod(method string) bool {
+	methods := p.RetryableMethods
+	if methods == nil {
+		methods = DefaultRetryableMethods
+	}
+
+	for _, retryable := range methods {
+		if strings.EqualFold(retryable, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = DefaultInitialBackoff
+	}
+
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = DefaultBackoffFactor
+	}
+
+	backoff := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if backoff > float64(maxBackoff) {
+		backoff = float64(maxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		jitter := math.Min(p.Jitter, 1)
+		backoff -= backoff * jitter * rand.Float64()
+	}
+	return time.Duration(backoff)
+}
+
+// IsRetryableError reports transient transport failures: timeouts, refused or
+// reset connections and connections closed before a response was received.
+// DNS and TLS failures are only retried when they timed out.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	for _, target := range []error{ErrStatus, ErrDecompression, ErrBodyTooLarge, ErrRateLimited, ErrBulkheadFull, ErrLoadShed} {
+		if errors.Is(err, target) {
+			return false
+		}
+	}
+
+	if isTimeout(err) {
+		return true
+	}
+
+	if errors.Is(err, ErrTLS) || errors.Is(err, ErrDNS) {
+		return false
+	}
+
+	for _, target := range []error{
+		context.DeadlineExceeded,
+		syscall.ECONNRESET,
+		syscall.ECONNREFUSED,
+		syscall.ECONNABORTED,
+		syscall.EPIPE,
+		io.EOF,
+		io.ErrUnexpectedEOF,
+		fasthttp.ErrConnectionClosed,
+		fasthttp.ErrDialTimeout,
+		fasthttp.ErrNoFreeConns,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRetryAfter reads a Retry-After header given either as delay seconds or
+// as an HTTP-date relative to now. Delays too long for a time.Duration are
+// capped at its maximum.
+func ParseRetryAfter(headers map[string][]string, now time.Time) (time.Duration, bool) {
+	value := strings.TrimSpace(headerValue(headers, "Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+
+	// ParseInt returns the nearest int64 with ErrRange for longer numbers.
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil || errors.Is(err, strconv.ErrRange) {
+		switch {
+		case seconds < 0:
+			return 0, false
+		case seconds > math.MaxInt64/int64(time.Second):
+			return time.Duration(math.MaxInt64), true
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	if wait := date.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+func (p *RetryPolicy) throttleStatusCodes() []int {
+	if p == nil || p.ThrottleStatusCodes == nil {
+		return DefaultThrottleStatusCodes
+	}
+	return p.ThrottleStatusCodes
+}
+
+func (p *RetryPolicy) throttled(response Response, err error) error {
+	if err == nil || !containsStatus(p.throttleStatusCodes(), response.StatusCode) {
+		return err
+	}
+
+	retryAfter, ok := ParseRetryAfter(response.Headers, time.Now())
+	if !ok {
+		return err
+	}
+
+	return &ThrottleError{
+		StatusCode: response.StatusCode,
+		RetryAfter: retryAfter,
+		Err:        err,
+	}
+}
+
+func containsStatus(codes []int, status int) bool {
+	for _, code := range codes {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
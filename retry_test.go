@@ -0,0 +1,269 @@
+package ht2p_test
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/D3vl0per/ht2p"
+	"github.com/stretchr/testify/assert"
+	r "github.com/stretchr/testify/require"
+)
+
+func flakyServer(t *testing.T, failures int32, status int) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+
+		if hits.Add(1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		_, err = w.Write(body)
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func TestRetry(t *testing.T) {
+	policy := &ht2p.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Jitter:         0.5,
+	}
+
+	tests := []struct {
+		name             string
+		failures         int32
+		status           int
+		expectedAttempts int
+		expectedError    bool
+	}{
+		{
+			name:             "Success after transient failures",
+			failures:         2,
+			status:           http.StatusServiceUnavailable,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "Give up after max attempts",
+			failures:         5,
+			status:           http.StatusBadGateway,
+			expectedAttempts: 3,
+			expectedError:    true,
+		},
+		{
+			name:             "Non retryable status code",
+			failures:         1,
+			status:           http.StatusNotFound,
+			expectedAttempts: 1,
+			expectedError:    true,
+		},
+	}
+
+	for clientName, newClient := range backends {
+		for _, test := range tests {
+			t.Run(clientName+" "+test.name, func(t *testing.T) {
+				server, hits := flakyServer(t, test.failures, test.status)
+
+				response, err := newClient(&ht2p.NetHttp{
+					URL:    server.URL,
+					Method: http.MethodPut,
+					Body:   []byte("payload"),
+					Ctx:    context.Background(),
+					Retry:  policy,
+				}).Request()
+				r.Equal(t, test.expectedAttempts, response.Attempts)
+				r.Equal(t, int32(test.expectedAttempts), hits.Load())
+
+				if test.expectedError {
+					r.Error(t, err)
+					r.Equal(t, test.status, response.StatusCode)
+					return
+				}
+				r.NoError(t, err)
+				r.Equal(t, []byte("payload"), response.Body)
+			})
+		}
+	}
+}
+
+func TestRetryMethods(t *testing.T) {
+	tests := []struct {
+		name             string
+		method           string
+		methods          []string
+		expectedAttempts int
+	}{
+		{
+			name:             "Idempotent method",
+			method:           http.MethodDelete,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "Non idempotent method",
+			method:           http.MethodPost,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "Non idempotent method opted in",
+			method:           http.MethodPost,
+			methods:          []string{http.MethodPost},
+			expectedAttempts: 3,
+		},
+	}
+
+	for _, test := range tests {
+		policy := &ht2p.RetryPolicy{InitialBackoff: time.Millisecond, RetryableMethods: test.methods}
+		for name, newClient := range backends {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				server, hits := flakyServer(t, 5, http.StatusBadGateway)
+
+				response, err := newClient(&ht2p.NetHttp{URL: server.URL, Method: test.method, Retry: policy}).Request()
+				r.Error(t, err)
+				r.Equal(t, test.expectedAttempts, response.Attempts)
+				r.Equal(t, int32(test.expectedAttempts), hits.Load())
+			})
+		}
+	}
+}
+
+func TestRetryTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	t.Cleanup(server.Close)
+
+	policy := &ht2p.RetryPolicy{
+		MaxAttempts:       10,
+		InitialBackoff:    time.Millisecond,
+		PerAttemptTimeout: 10 * time.Millisecond,
+		Timeout:           100 * time.Millisecond,
+	}
+
+	for name, client := range map[string]ht2p.HttpClient{
+		"net/http": &ht2p.NetHttp{URL: server.URL, Ctx: context.Background(), Retry: policy},
+		"fasthttp": &ht2p.FastHttp{URL: server.URL, Retry: policy},
+	} {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now()
+			response, err := client.Request()
+			r.Error(t, err)
+			r.Greater(t, response.Attempts, 1)
+			r.Less(t, response.Attempts, 10)
+			r.Less(t, time.Since(start), time.Second)
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name               string
+		retryAfter         string
+		expectedAttempts   int
+		expectedRetryAfter time.Duration
+		expectedError      bool
+	}{
+		{
+			name:             "Delay seconds",
+			retryAfter:       "0",
+			expectedAttempts: 2,
+		},
+		{
+			name:             "HTTP date in the past",
+			retryAfter:       time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat),
+			expectedAttempts: 2,
+		},
+		{
+			name:               "Wait exceeds max retry after",
+			retryAfter:         "120",
+			expectedAttempts:   1,
+			expectedRetryAfter: 120 * time.Second,
+			expectedError:      true,
+		},
+		{
+			name:               "Wait overflows a duration",
+			retryAfter:         "10000000000",
+			expectedAttempts:   1,
+			expectedRetryAfter: time.Duration(math.MaxInt64),
+			expectedError:      true,
+		},
+	}
+
+	policy := &ht2p.RetryPolicy{
+		InitialBackoff: time.Millisecond,
+		MaxRetryAfter:  time.Second,
+	}
+
+	for _, test := range tests {
+		test := test
+		var hits atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if hits.Add(1) == 1 {
+				w.Header().Set("Retry-After", test.retryAfter)
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		}))
+		t.Cleanup(server.Close)
+
+		for name, client := range map[string]ht2p.HttpClient{
+			"net/http": &ht2p.NetHttp{URL: server.URL, Ctx: context.Background(), Retry: policy},
+			"fasthttp": &ht2p.FastHttp{URL: server.URL, Retry: policy},
+		} {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				hits.Store(0)
+				response, err := client.Request()
+				r.Equal(t, test.expectedAttempts, response.Attempts)
+
+				if !test.expectedError {
+					r.NoError(t, err)
+					return
+				}
+
+				var throttleErr *ht2p.ThrottleError
+				r.ErrorAs(t, err, &throttleErr)
+				r.Equal(t, http.StatusTooManyRequests, throttleErr.StatusCode)
+				r.Equal(t, test.expectedRetryAfter, throttleErr.RetryAfter)
+			})
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{name: "Seconds", value: "30", expected: 30 * time.Second, ok: true},
+		{name: "HTTP date", value: now.Add(time.Minute).Format(http.TimeFormat), expected: time.Minute, ok: true},
+		{name: "Overflowing seconds", value: "10000000000", expected: time.Duration(math.MaxInt64), ok: true},
+		{name: "Out of range seconds", value: "99999999999999999999", expected: time.Duration(math.MaxInt64), ok: true},
+		{name: "Negative seconds", value: "-1"},
+		{name: "Garbage", value: "soon"},
+		{name: "Missing"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := map[string][]string{}
+			if test.value != "" {
+				headers["Retry-After"] = []string{test.value}
+			}
+
+			wait, ok := ht2p.ParseRetryAfter(headers, now)
+			r.Equal(t, test.ok, ok)
+			r.Equal(t, test.expected, wait)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package ht2p_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/D3vl0per/crypt/compression"
+	"github.com/D3vl0per/ht2p"
+	"github.com/stretchr/testify/assert"
+	r "github.com/stretchr/testify/require"
+)
+
+func encodingServer(t *testing.T, payload []byte) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body := payload
+		encoding := req.URL.Query().Get("encoding")
+		for _, name := range strings.Split(encoding, ",") {
+			compressor := compressorByName(strings.TrimSpace(name))
+			if compressor == nil {
+				continue
+			}
+			compressed, err := compressor.Compress(body)
+			assert.NoError(t, err)
+			body = append([]byte(nil), compressed...)
+		}
+
+		if encoding != "" {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		_, err := w.Write(body)
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func compressorByName(name string) compression.Compressor {
+	switch name {
+	case "gzip":
+		return &compression.Gzip{Level: compression.BestSpeed}
+	case "deflate":
+		return &compression.Zlib{Level: compression.BestSpeed}
+	case "br":
+		brotli := &compression.Brotli{}
+		brotli.SetLevel(compression.BrotliBestSpeed)
+		return brotli
+	case "zstd":
+		return &compression.Zstd{Level: compression.ZstdSpeedFastest}
+	}
+	return nil
+}
+
+func TestStream(t *testing.T) {
+	payload := bytes.Repeat([]byte("streamed payload "), 64*1024)
+	server := encodingServer(t, payload)
+
+	tests := []struct {
+		name     string
+		encoding string
+	}{
+		{name: "Identity"},
+		{name: "Gzip", encoding: "gzip"},
+		{name: "Brotli", encoding: "br"},
+		{name: "Deflate", encoding: "deflate"},
+		{name: "Stacked", encoding: "gzip,br"},
+	}
+
+	for _, test := range tests {
+		url := server.URL + "?encoding=" + test.encoding
+		for name, client := range map[string]ht2p.ExtendedClient{
+			"net/http": &ht2p.NetHttp{URL: url, Ctx: context.Background()},
+			"fasthttp": &ht2p.FastHttp{URL: url},
+		} {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				stream, err := client.Stream()
+				r.NoError(t, err)
+				r.Equal(t, http.StatusOK, stream.StatusCode)
+				r.Equal(t, 1, stream.Attempts)
+
+				body, err := io.ReadAll(stream.Body)
+				r.NoError(t, err)
+				r.NoError(t, stream.Body.Close())
+				r.Equal(t, payload, body)
+			})
+		}
+	}
+}
+
+func TestStreamStatusMismatch(t *testing.T) {
+	server := testServer(t, 0, http.StatusNotFound, "missing")
+
+	for name, client := range map[string]ht2p.ExtendedClient{
+		"net/http": &ht2p.NetHttp{URL: server.URL, Ctx: context.Background()},
+		"fasthttp": &ht2p.FastHttp{URL: server.URL},
+	} {
+		t.Run(name, func(t *testing.T) {
+			stream, err := client.Stream()
+			r.Error(t, err)
+			r.Equal(t, http.StatusNotFound, stream.StatusCode)
+
+			body, err := io.ReadAll(stream.Body)
+			r.NoError(t, err)
+			r.Equal(t, "missing", string(body))
+		})
+	}
+}
+
+func TestStreamAttemptTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, err := w.Write([]byte("first "))
+		assert.NoError(t, err)
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		_, err = w.Write([]byte("second"))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+
+	retry := &ht2p.RetryPolicy{MaxAttempts: 1, PerAttemptTimeout: 50 * time.Millisecond}
+	for name, client := range map[string]ht2p.ExtendedClient{
+		"net/http": &ht2p.NetHttp{URL: server.URL, Ctx: context.Background(), Retry: retry},
+		"fasthttp": &ht2p.FastHttp{URL: server.URL, Retry: retry},
+	} {
+		t.Run(name, func(t *testing.T) {
+			stream, err := client.Stream()
+			r.NoError(t, err)
+
+			// The attempt timeout ends with the headers and leaves the body to Ctx.
+			body, err := io.ReadAll(stream.Body)
+			r.NoError(t, err)
+			r.NoError(t, stream.Body.Close())
+			r.Equal(t, "first second", string(body))
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package ht2p
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/D3vl0per/crypt/generic"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	phaseTotal        = "total"
+	phaseConnect      = "connect"
+	phaseTLSHandshake = "tls handshake"
+	phaseFirstByte    = "first byte"
+	phaseIdleRead     = "idle read"
+)
+
+// Timeouts bound a single exchange with the server, a zero value disables the
+// timeout. Total covers the whole exchange including reading the body,
+// FirstByte runs from the written request to the first response byte and
+// IdleRead bounds every wait for more response data.
+type Timeouts struct {
+	Total        time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	FirstByte    time.Duration
+	IdleRead     time.Duration
+}
+
+// TimeoutError names the timeout of Timeouts that expired.
+type TimeoutError struct {
+	Phase    string
+	Duration time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return generic.StrCnct([]string{"request timed out [", e.Phase, "]: exceeded ", e.Duration.String()}...)
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+func (t Timeouts) phases() bool {
+	return t.Connect > 0 || t.TLSHandshake > 0 || t.FirstByte > 0 || t.IdleRead > 0
+}
+
+// total bounds ctx by Total, its expiry is the cause of the cancellation.
+func (t Timeouts) total(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.Total <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, t.Total, &TimeoutError{Phase: phaseTotal, Duration: t.Total})
+}
+
+// phaseTimers cancel a request with a TimeoutError once a phase outlives its
+// timeout.
+type phaseTimers struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+	cancel context.CancelCauseFunc
+}
+
+func (p *phaseTimers) start(phase string, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.timers[phase]; ok {
+		return
+	}
+	p.timers[phase] = time.AfterFunc(timeout, func() {
+		p.cancel(&TimeoutError{Phase: phase, Duration: timeout})
+	})
+}
+
+func (p *phaseTimers) stop(phase string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if timer, ok := p.timers[phase]; ok {
+		timer.Stop()
+		delete(p.timers, phase)
+	}
+}
+
+// netTimeouts applies Timeouts to one net/http exchange through the request
+// context, the phases are tracked with httptrace.
+type netTimeouts struct {
+	phaseTimers
+	ctx      context.Context
+	idleRead time.Duration
+}
+
+func newNetTimeouts(ctx context.Context, timeouts Timeouts) *netTimeouts {
+	ctx, cancel := context.WithCancelCause(ctx)
+	n := &netTimeouts{
+		phaseTimers: phaseTimers{timers: make(map[string]*time.Timer), cancel: cancel},
+		idleRead:    timeouts.IdleRead,
+	}
+
+	if timeouts.phases() {
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			ConnectStart:      func(_, _ string) { n.start(phaseConnect, timeouts.Connect) },
+			ConnectDone:       func(_, _ string, _ error) { n.stop(phaseConnect) },
+			TLSHandshakeStart: func() { n.start(phaseTLSHandshake, timeouts.TLSHandshake) },
+			TLSHandshakeDone:  func(tls.ConnectionState, error) { n.stop(phaseTLSHandshake) },
+			WroteRequest:      func(httptrace.WroteRequestInfo) { n.start(phaseFirstByte, timeouts.FirstByte) },
+			GotFirstResponseByte: func() {
+				n.stop(phaseFirstByte)
+				n.start(phaseIdleRead, timeouts.IdleRead)
+			},
+		})
+	}
+	n.ctx = ctx
+	n.start(phaseTotal, timeouts.Total)
+	return n
+}
+
+// err prefers the expired timeout over the cancellation it caused.
+func (n *netTimeouts) err(err error) error {
+	var timeoutErr *TimeoutError
+	if err != nil && errors.As(context.Cause(n.ctx), &timeoutErr) {
+		return timeoutErr
+	}
+	return err
+}
+
+// headers stops the timers of the phases before the response body.
+func (n *netTimeouts) headers() {
+	n.stop(phaseConnect)
+	n.stop(phaseTLSHandshake)
+	n.stop(phaseFirstByte)
+	n.stop(phaseIdleRead)
+}
+
+func (n *netTimeouts) close() {
+	n.mu.Lock()
+	for phase, timer := range n.timers {
+		timer.Stop()
+		delete(n.timers, phase)
+	}
+	n.mu.Unlock()
+	n.cancel(nil)
+}
+
+// netTimeoutBody rearms the idle read timer around every read of the body and
+// releases the timers on Close.
+type netTimeoutBody struct {
+	io.ReadCloser
+	timeouts *netTimeouts
+}
+
+func (b *netTimeoutBody) Read(p []byte) (int, error) {
+	b.timeouts.stop(phaseIdleRead)
+	b.timeouts.start(phaseIdleRead, b.timeouts.idleRead)
+	n, err := b.ReadCloser.Read(p)
+	b.timeouts.stop(phaseIdleRead)
+	if err != nil && err != io.EOF {
+		err = b.timeouts.err(err)
+	}
+	return n, err
+}
+
+func (b *netTimeoutBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.timeouts.close()
+	return err
+}
+
+// configureClient installs a dialer on every fasthttp host client which
+// applies Connect, TLSHandshake, FirstByte and IdleRead, fasthttp itself only
+// knows whole request timeouts. Connect only bounds the built-in dialers.
+// fasthttp reports read timeouts as its own ErrTimeout and retries idempotent
+// requests after them, so FirstByte and IdleRead apply per fasthttp attempt.
+func (t *Timeouts) configureClient(configure func(*fasthttp.HostClient) error) func(*fasthttp.HostClient) error {
+	return func(hc *fasthttp.HostClient) error {
+		if configure != nil {
+			if err := configure(hc); err != nil {
+				return err
+			}
+		}
+
+		dial, dualStack := hc.Dial, hc.DialDualStack
+		var tlsConfig *tls.Config
+		if hc.IsTLS {
+			tlsConfig = hc.TLSConfig.Clone()
+			if tlsConfig == nil {
+				tlsConfig = &tls.Config{}
+			}
+			if tlsConfig.ServerName == "" {
+				host, _, err := net.SplitHostPort(hc.Addr)
+				if err != nil {
+					host = hc.Addr
+				}
+				tlsConfig.ServerName = host
+			}
+		}
+
+		hc.Dial = func(addr string) (net.Conn, error) {
+			return t.dial(addr, dial, dualStack, tlsConfig)
+		}
+		return nil
+	}
+}
+
+func (t *Timeouts) dial(addr string, dial fasthttp.DialFunc, dualStack bool, tlsConfig *tls.Config) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	switch {
+	case dial != nil:
+		conn, err = dial(addr)
+	case t.Connect > 0 && dualStack:
+		conn, err = fasthttp.DialDualStackTimeout(addr, t.Connect)
+	case t.Connect > 0:
+		conn, err = fasthttp.DialTimeout(addr, t.Connect)
+	case dualStack:
+		conn, err = fasthttp.DialDualStack(addr)
+	default:
+		conn, err = fasthttp.Dial(addr)
+	}
+	if err != nil {
+		if t.Connect > 0 && (errors.Is(err, fasthttp.ErrDialTimeout) || isTimeout(err)) {
+			return nil, &TimeoutError{Phase: phaseConnect, Duration: t.Connect}
+		}
+		return nil, err
+	}
+
+	if tlsConfig == nil {
+		return &timeoutConn{Conn: conn, timeouts: t}, nil
+	}
+
+	// fasthttp skips its own handshake for conns with a Handshake method.
+	tlsConn := tls.Client(conn, tlsConfig)
+	if t.TLSHandshake > 0 {
+		tlsConn.SetDeadline(time.Now().Add(t.TLSHandshake))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		if t.TLSHandshake > 0 && isTimeout(err) {
+			return nil, &TimeoutError{Phase: phaseTLSHandshake, Duration: t.TLSHandshake}
+		}
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	return &timeoutTLSConn{timeoutConn: timeoutConn{Conn: tlsConn, timeouts: t}, tls: tlsConn}, nil
+}
+
+// timeoutConn shortens the read deadline fasthttp sets to FirstByte after a
+// request was written and to IdleRead once the response started.
+type timeoutConn struct {
+	net.Conn
+	timeouts *Timeouts
+	deadline time.Time
+	waiting  bool
+}
+
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	c.waiting = true
+	return c.Conn.Write(p)
+}
+
+func (c *timeoutConn) SetDeadline(deadline time.Time) error {
+	c.deadline = deadline
+	return c.Conn.SetDeadline(deadline)
+}
+
+func (c *timeoutConn) SetReadDeadline(deadline time.Time) error {
+	c.deadline = deadline
+	return c.Conn.SetReadDeadline(deadline)
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	phase, timeout := phaseIdleRead, c.timeouts.IdleRead
+	if c.waiting {
+		phase, timeout = phaseFirstByte, c.timeouts.FirstByte
+	}
+
+	deadline := c.deadline
+	if phaseDeadline := time.Now().Add(timeout); timeout > 0 && (deadline.IsZero() || phaseDeadline.Before(deadline)) {
+		deadline = phaseDeadline
+	} else {
+		phase = ""
+	}
+	if err := c.Conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.waiting = false
+	}
+	if err != nil && phase != "" && isTimeout(err) {
+		err = &TimeoutError{Phase: phase, Duration: timeout}
+	}
+	return n, err
+}
+
+type timeoutTLSConn struct {
+	timeoutConn
+	tls *tls.Conn
+}
+
+func (c *timeoutTLSConn) Handshake() error {
+	return c.tls.Handshake()
+}
@@ -0,0 +1,185 @@
+package ht2p_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/D3vl0per/ht2p"
+	"github.com/stretchr/testify/assert"
+	r "github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func TestTimeouts(t *testing.T) {
+	slow := testServer(t, time.Second, http.StatusOK, "slow")
+	fast := testServer(t, 0, http.StatusOK, "fast")
+
+	stalling := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, err := w.Write([]byte("partial"))
+		assert.NoError(t, err)
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-time.After(time.Second):
+		case <-req.Context().Done():
+		}
+	}))
+	t.Cleanup(stalling.Close)
+
+	trickling := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for i := 0; i < 20; i++ {
+			if _, err := w.Write([]byte("drop")); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+
+			select {
+			case <-time.After(25 * time.Millisecond):
+			case <-req.Context().Done():
+				return
+			}
+		}
+	}))
+	t.Cleanup(trickling.Close)
+
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, err := w.Write([]byte("secure"))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(secure.Close)
+
+	// Accepts connections but never answers the TLS handshake.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(t, err)
+	t.Cleanup(func() { silent.Close() })
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	tests := []struct {
+		name          string
+		url           string
+		timeouts      ht2p.Timeouts
+		expectedKind  error
+		expectedPhase string
+	}{
+		{
+			name:     "Within timeouts",
+			url:      fast.URL,
+			timeouts: ht2p.Timeouts{Total: 5 * time.Second, Connect: time.Second, FirstByte: time.Second, IdleRead: time.Second},
+		},
+		{
+			name:     "TLS within timeouts",
+			url:      secure.URL,
+			timeouts: ht2p.Timeouts{Total: 5 * time.Second, TLSHandshake: time.Second, FirstByte: time.Second},
+		},
+		{
+			name:          "Total",
+			url:           trickling.URL,
+			timeouts:      ht2p.Timeouts{Total: 200 * time.Millisecond, IdleRead: time.Second},
+			expectedKind:  ht2p.ErrTimeout,
+			expectedPhase: "total",
+		},
+		{
+			name:          "First byte",
+			url:           slow.URL,
+			timeouts:      ht2p.Timeouts{FirstByte: 50 * time.Millisecond},
+			expectedKind:  ht2p.ErrTimeout,
+			expectedPhase: "first byte",
+		},
+		{
+			name:          "Idle read",
+			url:           stalling.URL,
+			timeouts:      ht2p.Timeouts{IdleRead: 50 * time.Millisecond},
+			expectedKind:  ht2p.ErrTimeout,
+			expectedPhase: "idle read",
+		},
+		{
+			name:          "TLS handshake",
+			url:           "https://" + silent.Addr().String(),
+			timeouts:      ht2p.Timeouts{TLSHandshake: 50 * time.Millisecond},
+			expectedKind:  ht2p.ErrTLS,
+			expectedPhase: "tls handshake",
+		},
+	}
+
+	for _, test := range tests {
+		for name, client := range map[string]ht2p.HttpClient{
+			"net/http": &ht2p.NetHttp{URL: test.url, Ctx: context.Background(), Client: *secure.Client(), Timeouts: test.timeouts},
+			"fasthttp": &ht2p.FastHttp{
+				URL:      test.url,
+				Client:   fasthttp.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}},
+				Timeouts: test.timeouts,
+			},
+		} {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				start := time.Now()
+				_, err := client.Request()
+				r.Less(t, time.Since(start), 900*time.Millisecond)
+
+				if test.expectedKind == nil {
+					r.NoError(t, err)
+					return
+				}
+				r.ErrorIs(t, err, test.expectedKind)
+				r.ErrorIs(t, err, ht2p.ErrTimeout)
+
+				// fasthttp replaces read timeouts with its own error.
+				var timeoutErr *ht2p.TimeoutError
+				if name == "net/http" || test.expectedPhase != "first byte" {
+					r.ErrorAs(t, err, &timeoutErr)
+					r.Equal(t, test.expectedPhase, timeoutErr.Phase)
+				}
+			})
+		}
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	stalling := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, err := w.Write([]byte("partial"))
+		assert.NoError(t, err)
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-time.After(time.Second):
+		case <-req.Context().Done():
+		}
+	}))
+	t.Cleanup(stalling.Close)
+
+	timeouts := ht2p.Timeouts{FirstByte: time.Second, IdleRead: 50 * time.Millisecond}
+	for name, client := range map[string]ht2p.ExtendedClient{
+		"net/http": &ht2p.NetHttp{URL: stalling.URL, Ctx: context.Background(), Timeouts: timeouts},
+		"fasthttp": &ht2p.FastHttp{URL: stalling.URL, Timeouts: timeouts},
+	} {
+		t.Run(name, func(t *testing.T) {
+			stream, err := client.Stream()
+			r.NoError(t, err)
+			defer stream.Body.Close()
+
+			// Time spent between reads is not idle time.
+			time.Sleep(100 * time.Millisecond)
+
+			buffer := make([]byte, 7)
+			_, err = stream.Body.Read(buffer)
+			r.NoError(t, err)
+			r.Equal(t, "partial", string(buffer))
+
+			_, err = stream.Body.Read(buffer)
+			r.True(t, errors.Is(err, ht2p.ErrTimeout), err)
+		})
+	}
+}